@@ -15,24 +15,95 @@
 package app
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/deckhouse/rbacgen/internal/engine"
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/diff"
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/output"
+	"github.com/deckhouse/rbacgen/internal/engine/scaffold"
+	"github.com/deckhouse/rbacgen/internal/engine/schema"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+)
+
+var (
+	extraDirs []string
+	rev       string
+
+	outputPath string
+	outputDir  string
+
+	selectedModules    []string
+	selectedSubsystems []string
+
+	prune      bool
+	dryRun     bool
+	reportPath string
+
+	listFormat string
+
+	initName       string
+	initNamespace  string
+	initSubsystems []string
+	initForce      bool
+
+	logLevel  string
+	logFormat string
 )
 
 func init() {
+	root.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log level: debug, info, warn or error")
+	root.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format: text or json")
+
+	for _, cmd := range []*cobra.Command{generateCmd, checkCmd} {
+		cmd.Flags().StringSliceVar(&selectedModules, "module", nil, "generate only the modules with the names, other modules are kept in docs")
+		cmd.Flags().StringSliceVar(&selectedSubsystems, "subsystem", nil, "generate only the modules of the subsystems, other modules are kept in docs")
+		cmd.Flags().StringSliceVar(&extraDirs, "workdir", nil, "additional workdir, its modules are generated together with the workdir modules into the same docs")
+		cmd.Flags().StringVar(&rev, "rev", "", "read modules and CRDs from the git revision of the local repository instead of the working tree")
+	}
+
+	generateCmd.Flags().StringVarP(&outputPath, "output", "o", "", "write all roles as one multi-document yaml to the file, '-' for stdout")
+	generateCmd.Flags().StringVar(&outputDir, "output-dir", "", "mirror the generated layout into the dir instead of module dirs")
+	generateCmd.MarkFlagsMutuallyExclusive("output", "output-dir")
+	generateCmd.Flags().BoolVar(&prune, "prune", false, "remove files in templates/rbacv2 of the generated modules which were not generated by the run")
+	generateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "write nothing, only list files which would be pruned")
+	generateCmd.MarkFlagsMutuallyExclusive("output", "prune")
+	generateCmd.Flags().StringVar(&reportPath, "report", "", "write the json report of the run to the file")
+
 	root.AddCommand(generateCmd)
+	root.AddCommand(checkCmd)
+	listCmd.Flags().StringVarP(&listFormat, "format", "f", "table", "output format: table or json")
+
+	root.AddCommand(diffCmd)
+	root.AddCommand(listCmd)
+	root.AddCommand(explainCmd)
+
+	initCmd.Flags().StringVar(&initName, "name", "", "module name, the module dir name without the weight prefix by default")
+	initCmd.Flags().StringVar(&initNamespace, "namespace", "", "module namespace")
+	initCmd.Flags().StringSliceVar(&initSubsystems, "subsystem", nil, "module subsystems")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "overwrite existing module.yaml and rbac.yaml")
+	root.AddCommand(initCmd)
+	root.AddCommand(schemaCmd)
 }
 
 var root = &cobra.Command{
 	Use:   "rbacgen",
 	Short: "rbacgen - a tool to generate RBACv2 roles",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupLogger(cmd.ErrOrStderr())
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Use some command")
 	},
@@ -40,22 +111,372 @@ var root = &cobra.Command{
 
 func Execute() {
 	if err := root.Execute(); err != nil {
-		log.Printf("failed to execute: '%s'", err)
+		slog.Error("failed to execute", "error", err)
 		os.Exit(1)
 	}
 }
 
+// setupLogger sets the default logger by the log flags, logs are written to stderr to keep stdout for output
+func setupLogger(w io.Writer) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("invalid log level '%s'", logLevel)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(w, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, opts)))
+	default:
+		return fmt.Errorf("invalid log format '%s'", logFormat)
+	}
+
+	return nil
+}
+
 var generateCmd = &cobra.Command{
-	Use:     "generate",
-	Short:   "Generate roles and docs by walking over the specific dir",
-	Example: "rbacgen generate ee docs.yaml - to generate roles only from ee dir\nrbacgen generate . docs.yaml - to generate roles from the current dir",
+	Use:   "generate",
+	Short: "Generate roles and docs by walking over the specific dir",
+	Example: "rbacgen generate ee docs.yaml - to generate roles only from ee dir\nrbacgen generate . docs.yaml - to generate roles from the current dir\n" +
+		"rbacgen generate . -o - - to print roles to stdout\nrbacgen generate . docs.yaml --output-dir out - to write roles and docs to the out dir\n" +
+		"rbacgen generate . docs.yaml --module user-authz --subsystem network - to generate only the selected modules and merge them into docs\n" +
+		"rbacgen generate . docs.yaml --prune - to remove stale roles\nrbacgen generate . docs.yaml --dry-run - to list stale roles without writing anything\n" +
+		"rbacgen generate . docs.yaml --workdir ../external-modules - to generate modules of both dirs into one docs\n" +
+		"rbacgen generate . --rev v1.60.0 -o - - to print roles generated from the revision\n" +
+		"rbacgen generate module.tar.gz docs.yaml --output-dir out - to generate roles of the module bundle into the out dir",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		opts, err := parseOptions(args)
+		if err != nil {
+			return err
+		}
+
+		var out, docsOut output.Writer = output.Disk{}, output.Disk{}
+		switch {
+		case opts.Source.Bundle && outputPath == "" && outputDir == "":
+			return errors.New("roles of the bundle can not be written into it, use --output or --output-dir")
+		case opts.Source.Rev != "" && outputPath == "" && outputDir == "":
+			return errors.New("roles and docs of the revision would overwrite the working tree, use --output or --output-dir")
+		case outputPath == "-":
+			out = output.Stream{W: cmd.OutOrStdout()}
+		case outputPath != "":
+			file, createErr := os.Create(outputPath)
+			if createErr != nil {
+				return createErr
+			}
+			defer func() {
+				if closeErr := file.Close(); err == nil {
+					err = closeErr
+				}
+			}()
+			out = output.Stream{W: file}
+		case outputDir != "":
+			if out, err = output.NewDir(outputDir, opts.Dir, opts.ExtraDirs); err != nil {
+				return err
+			}
+			docsOut = out
+		}
+		// docs of the revision would overwrite the working tree too, they are written with the roles
+		if opts.Source.Rev != "" {
+			docsOut = out
+		}
+		opts.Out, opts.DocsOut = out, docsOut
+		opts.Prune, opts.DryRun = prune || dryRun, dryRun
+
+		result, err := engine.WalkAndRender(context.Background(), opts)
+		if err != nil {
+			return err
+		}
+		for _, path := range result.Pruned {
+			if dryRun {
+				cmd.PrintErrf("Would remove '%s'\n", path)
+				continue
+			}
+			cmd.PrintErrf("Removed '%s'\n", path)
+		}
+		if reportPath != "" {
+			return result.WriteReport(reportPath)
+		}
+		return nil
+	},
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check that generated roles and docs are up to date without writing anything",
+	Example: "rbacgen check . docs.yaml - to check roles and docs generated from the current dir\n" +
+		"rbacgen check . docs.yaml --rev HEAD - to check roles and docs committed in the revision",
+	// drift is not a usage error
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		opts, err := parseOptions(args)
+		if err != nil {
+			return err
+		}
+		drift, err := engine.Check(context.Background(), opts, cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+		if drift {
+			return errors.New("generated roles or docs are out of date, run 'rbacgen generate'")
+		}
+		return nil
+	},
+}
+
+// parseOptions parses the workdir and the optional docs path, the docs path from the workdir config is used by default,
+// the config of the workdir applies to the additional workdirs too
+func parseOptions(args []string) (engine.Options, error) {
+	if len(args) < 1 {
+		return engine.Options{}, errors.New("workdir is required")
+	}
+	if len(args) > 2 {
+		return engine.Options{}, fmt.Errorf("too many arguments")
+	}
+
+	src, err := source.Open(args[0], rev)
+	if err != nil {
+		return engine.Options{}, err
+	}
+	cfg, err := config.Load(src, args[0])
+	if err != nil {
+		return engine.Options{}, err
+	}
+
+	opts := engine.Options{Dir: args[0], ExtraDirs: extraDirs, Source: src, Config: cfg, Modules: selectedModules, Subsystems: selectedSubsystems}
+	if len(args) == 2 {
+		opts.DocsPath = args[1]
+	} else if cfg.DocsPath != "" {
+		opts.DocsPath = filepath.Join(args[0], cfg.DocsPath)
+	}
+
+	return opts, nil
+}
+
+var diffCmd = &cobra.Command{
+	Use:     "diff",
+	Short:   "Show permissions added to or removed from roles between two generations",
+	Example: "rbacgen diff old-docs.yaml . - to compare roles from the old docs with roles in the current dir\nrbacgen diff old-docs.yaml docs.yaml - to compare two docs",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) < 2 {
+			return errors.New("old and new roles paths are required")
+		}
+		if len(args) > 2 {
+			return fmt.Errorf("too many arguments")
+		}
+		oldRoles, err := diff.Load(args[0])
+		if err != nil {
+			return err
+		}
+		newRoles, err := diff.Load(args[1])
+		if err != nil {
+			return err
+		}
+		return diff.Print(cmd.OutOrStdout(), diff.Compare(oldRoles, newRoles))
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List modules found by walking over the specific dir",
+	Example: "rbacgen list . - to list modules in the current dir\nrbacgen list . -f json - to list modules with the matched CRD files as json\n" +
+		"rbacgen list module.tar.gz - to list modules of the bundle",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) < 1 {
+			return errors.New("workdir is required")
+		}
+		if len(args) > 1 {
+			return fmt.Errorf("too many arguments")
+		}
+		src, err := source.Open(args[0], "")
+		if err != nil {
+			return err
+		}
+		cfg, err := config.Load(src, args[0])
+		if err != nil {
+			return err
+		}
+		infos, err := engine.List(src, cfg, args[0])
+		if err != nil {
+			return err
+		}
+		switch listFormat {
+		case "table":
+			return engine.PrintTable(cmd.OutOrStdout(), infos)
+		case "json":
+			return engine.PrintJSON(cmd.OutOrStdout(), infos)
+		default:
+			return fmt.Errorf("unknown format '%s'", listFormat)
+		}
+	},
+}
+
+var explainCmd = &cobra.Command{
+	Use:     "explain",
+	Short:   "Explain filtering decisions made for every CRD of the module",
+	Example: "rbacgen explain . user-authz - to explain roles of the user-authz module found in the current dir",
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		if len(args) < 2 {
-			return errors.New("workdir and docs path are required")
+			return errors.New("workdir and module name are required")
 		}
 		if len(args) > 2 {
 			return fmt.Errorf("too many arguments")
 		}
-		return engine.WalkAndRender(context.Background(), args[0], args[1])
+		src, err := source.Open(args[0], "")
+		if err != nil {
+			return err
+		}
+		cfg, err := config.Load(src, args[0])
+		if err != nil {
+			return err
+		}
+		explanation, err := engine.Explain(context.Background(), src, cfg, args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return engine.PrintExplanation(cmd.OutOrStdout(), explanation)
 	},
 }
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create module.yaml and rbac.yaml for the module by inspecting its CRDs",
+	Example: "rbacgen init modules/040-node-manager - to prompt for subsystems and namespace\n" +
+		"rbacgen init modules/040-node-manager --subsystem kubernetes --namespace d8-cloud-instance-manager - to init without prompts",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) < 1 {
+			return errors.New("module dir is required")
+		}
+		if len(args) > 1 {
+			return fmt.Errorf("too many arguments")
+		}
+
+		// the module dir is usually inside the workdir, so the config is discovered at the current dir
+		cfg, err := config.Load(source.Disk(), ".")
+		if err != nil {
+			return err
+		}
+		proposed, err := scaffold.Inspect(context.Background(), source.Disk(), cfg, args[0])
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if len(proposed.Groups) == 0 {
+			fmt.Fprintln(out, "No CRDs found")
+		}
+		for _, group := range sortedKeys(proposed.Groups) {
+			fmt.Fprintf(out, "Found group '%s': %s\n", group, strings.Join(proposed.Groups[group], ", "))
+		}
+
+		if initName != "" {
+			proposed.Definition.Name = initName
+		}
+		if initNamespace != "" {
+			proposed.Definition.Namespace = initNamespace
+		}
+		if len(initSubsystems) != 0 {
+			proposed.Definition.Subsystems = initSubsystems
+		}
+
+		if in := cmd.InOrStdin(); interactive(in) {
+			if err = prompt(in, out, proposed.Definition); err != nil {
+				return err
+			}
+		}
+
+		written, kept, err := proposed.Write(initForce)
+		if err != nil {
+			return err
+		}
+		for _, path := range written {
+			fmt.Fprintf(out, "Written '%s'\n", path)
+		}
+		for _, path := range kept {
+			fmt.Fprintf(out, "Kept existing '%s', use --force to overwrite it\n", path)
+		}
+		if len(proposed.Spec.AllowedResources) == 0 {
+			fmt.Fprintf(out, "Only allowed by default resources found, '%s' is not required\n", models.SpecFile)
+		}
+		return nil
+	},
+}
+
+var schemaCmd = &cobra.Command{
+	Use:       "schema",
+	Short:     "Print JSON Schema of module.yaml or rbac.yaml for editors",
+	Example:   "rbacgen schema module > module.schema.json - to print the module.yaml schema\nrbacgen schema rbac > rbac.schema.json - to print the rbac.yaml schema",
+	ValidArgs: []string{"module", "rbac"},
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) < 1 {
+			return errors.New("file kind is required: module or rbac")
+		}
+		if len(args) > 1 {
+			return fmt.Errorf("too many arguments")
+		}
+		var marshaled []byte
+		switch args[0] {
+		case "module":
+			// module.yaml has fields used by other tools
+			marshaled, err = schema.JSONSchemaLenient(models.DefinitionFile, models.Definition{})
+		case "rbac":
+			marshaled, err = schema.JSONSchema(models.SpecFile, models.Spec{})
+		default:
+			return fmt.Errorf("unknown file kind '%s'", args[0])
+		}
+		if err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(marshaled)
+		return err
+	},
+}
+
+// interactive returns true if the input is a terminal, input injected by callers is always prompted
+func interactive(in io.Reader) bool {
+	file, ok := in.(*os.File)
+	if !ok {
+		return true
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// prompt asks for the subsystems and the namespace if they are not set by flags
+func prompt(in io.Reader, out io.Writer, definition *models.Definition) error {
+	reader := bufio.NewReader(in)
+	if len(definition.Subsystems) == 0 {
+		fmt.Fprint(out, "Subsystems(comma-separated): ")
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		for _, subsystem := range strings.Split(line, ",") {
+			if subsystem = strings.TrimSpace(subsystem); subsystem != "" {
+				definition.Subsystems = append(definition.Subsystems, subsystem)
+			}
+		}
+	}
+	if definition.Namespace == "" {
+		fmt.Fprintf(out, "Namespace[d8-%s]: ", definition.Name)
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		definition.Namespace = strings.TrimSpace(line)
+		if definition.Namespace == "" {
+			definition.Namespace = "d8-" + definition.Name
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/deckhouse/rbacgen/internal/engine/schema"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+)
+
+// File is the repository-level config, it is discovered at the workdir
+const File = ".rbacgen.yaml"
+
+type Config struct {
+	// SkipDirs are names of dirs the walker does not descend into
+	SkipDirs []string `yaml:"skipDirs,omitempty"`
+	// Include contains globs of module dirs relative to the workdir, only matched modules are discovered if it is set
+	Include []string `yaml:"include,omitempty"`
+	// Exclude contains globs of paths relative to the workdir, matched dirs and modules are skipped
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Gitignore makes the walker skip paths ignored by .gitignore files
+	Gitignore bool `yaml:"gitignore,omitempty"`
+	// TrustedGroup resources are allowed for every module without rbac.yaml
+	TrustedGroup string `yaml:"trustedGroup,omitempty"`
+	// AllowedGroups resources are allowed for every module in addition to the trusted group
+	AllowedGroups []string `yaml:"allowedGroups,omitempty"`
+	// TemplatesPath is the path of generated roles relative to the module dir
+	TemplatesPath string `yaml:"templatesPath,omitempty"`
+	// DocsPath is the default docs path relative to the workdir
+	DocsPath string `yaml:"docsPath,omitempty"`
+	// Editions are module trees in the inheritance order, a module of an edition overrides or extends
+	// the module with the same name of the previous editions
+	Editions []Edition `yaml:"editions,omitempty"`
+	// CRDDiscovery is the rule set CRDs are discovered in module dirs by, in addition to rbac.yaml globs
+	CRDDiscovery CRDDiscovery `yaml:"crdDiscovery,omitempty"`
+}
+
+// CRDDiscovery rules apply the same way to every discovered dir
+type CRDDiscovery struct {
+	// Dirs are relative to the module dir
+	Dirs []string `yaml:"dirs,omitempty"`
+	// Depth is the number of nested dirs CRDs are searched in, 0 means the dir only and -1 means any depth
+	Depth int `yaml:"depth,omitempty"`
+	// Extensions of CRD files, like .yaml
+	Extensions []string `yaml:"extensions,omitempty"`
+}
+
+// Edition is a named root of the edition modules
+type Edition struct {
+	Name string `yaml:"name"`
+	// Path is relative to the workdir, modules in nested edition roots belong to the nested editions
+	Path string `yaml:"path"`
+}
+
+func Default() *Config {
+	return &Config{
+		SkipDirs:      []string{"internal", "crds", "testdata", "docs", ".github"},
+		Gitignore:     true,
+		TrustedGroup:  "deckhouse.io",
+		TemplatesPath: "templates/rbacv2",
+		CRDDiscovery: CRDDiscovery{
+			Dirs:       []string{"crds", "crds/internal", "crds/native"},
+			Extensions: []string{".yaml", ".yml", ".json"},
+		},
+	}
+}
+
+// Load reads the config from the dir of the source, fields which are not set keep default values
+func Load(src *source.Source, dir string) (*Config, error) {
+	cfg := Default()
+
+	path := filepath.Join(dir, File)
+	raw, err := src.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+
+	if err = schema.Decode(path, raw, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	apimachineryYaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/doc"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+)
+
+const clusterRoleKind = "ClusterRole"
+
+// Tuple is the smallest permission granted by a rule
+type Tuple struct {
+	Group        string
+	Resource     string
+	Verb         string
+	ResourceName string
+}
+
+func (t Tuple) String() string {
+	resource := t.Resource
+	if t.Group != "" {
+		resource += "." + t.Group
+	}
+	if t.ResourceName != "" {
+		resource += "/" + t.ResourceName
+	}
+	return fmt.Sprintf("%s %s", t.Verb, resource)
+}
+
+// RoleDiff contains tuples added to or removed from the role
+type RoleDiff struct {
+	Name    string
+	Added   []Tuple
+	Removed []Tuple
+}
+
+// Load reads roles from the generated docs file, a multi-document yaml file with cluster roles
+// or a directory which contains generated roles in templates dirs set by the directory config
+func Load(path string) (map[string][]rbacv1.PolicyRule, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return loadFile(path)
+	}
+
+	cfg, err := config.Load(source.Disk(), path)
+	if err != nil {
+		return nil, err
+	}
+	templatesPath := filepath.ToSlash(filepath.Clean(cfg.TemplatesPath))
+
+	roles := make(map[string][]rbacv1.PolicyRule)
+	err = filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".yaml" || !strings.Contains(filepath.ToSlash(path), templatesPath+"/") {
+			return nil
+		}
+		loaded, err := loadRoles(path)
+		if err != nil {
+			return err
+		}
+		for name, rules := range loaded {
+			roles[name] = append(roles[name], rules...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func loadFile(path string) (map[string][]rbacv1.PolicyRule, error) {
+	if docs, err := doc.Load(source.Disk(), path); err == nil && len(docs.Modules) != 0 {
+		return docs.Roles(), nil
+	}
+	return loadRoles(path)
+}
+
+func loadRoles(path string) (map[string][]rbacv1.PolicyRule, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string][]rbacv1.PolicyRule)
+	decoder := apimachineryYaml.NewYAMLOrJSONDecoder(bytes.NewReader(raw), 4096)
+	for {
+		var role *rbacv1.ClusterRole
+		if err = decoder.Decode(&role); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to decode '%s': %w", path, err)
+		}
+		// skip empty documents and other kinds
+		if role == nil || role.Kind != clusterRoleKind {
+			continue
+		}
+		roles[role.Name] = append(roles[role.Name], role.Rules...)
+	}
+
+	return roles, nil
+}
+
+// Compare reports tuples added to and removed from every role, ignoring rules order and grouping
+func Compare(old, new map[string][]rbacv1.PolicyRule) []RoleDiff {
+	names := sets.KeySet(old).Union(sets.KeySet(new)).UnsortedList()
+	sort.Strings(names)
+
+	var result []RoleDiff
+	for _, name := range names {
+		oldTuples, newTuples := expand(old[name]), expand(new[name])
+		roleDiff := RoleDiff{
+			Name:    name,
+			Added:   sortTuples(newTuples.Difference(oldTuples)),
+			Removed: sortTuples(oldTuples.Difference(newTuples)),
+		}
+		if len(roleDiff.Added) != 0 || len(roleDiff.Removed) != 0 {
+			result = append(result, roleDiff)
+		}
+	}
+
+	return result
+}
+
+// Print writes the diff in a human-readable form
+func Print(w io.Writer, diffs []RoleDiff) error {
+	for _, roleDiff := range diffs {
+		if _, err := fmt.Fprintln(w, roleDiff.Name); err != nil {
+			return err
+		}
+		for _, tuple := range roleDiff.Removed {
+			if _, err := fmt.Fprintf(w, "  - %s\n", tuple); err != nil {
+				return err
+			}
+		}
+		for _, tuple := range roleDiff.Added {
+			if _, err := fmt.Fprintf(w, "  + %s\n", tuple); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func expand(rules []rbacv1.PolicyRule) sets.Set[Tuple] {
+	tuples := sets.New[Tuple]()
+	for _, rule := range rules {
+		names := rule.ResourceNames
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				for _, verb := range rule.Verbs {
+					for _, name := range names {
+						tuples.Insert(Tuple{Group: group, Resource: resource, Verb: verb, ResourceName: name})
+					}
+				}
+			}
+		}
+	}
+	return tuples
+}
+
+func sortTuples(tuples sets.Set[Tuple]) []Tuple {
+	sorted := tuples.UnsortedList()
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.ResourceName != b.ResourceName {
+			return a.ResourceName < b.ResourceName
+		}
+		return a.Verb < b.Verb
+	})
+	return sorted
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		old  map[string][]rbacv1.PolicyRule
+		new  map[string][]rbacv1.PolicyRule
+		want []RoleDiff
+	}{
+		{
+			name: "regrouped rules are equal",
+			old: map[string][]rbacv1.PolicyRule{
+				"view": {{APIGroups: []string{"example.io"}, Resources: []string{"a", "b"}, Verbs: []string{"get", "list"}}},
+			},
+			new: map[string][]rbacv1.PolicyRule{
+				"view": {
+					{APIGroups: []string{"example.io"}, Resources: []string{"b"}, Verbs: []string{"list", "get"}},
+					{APIGroups: []string{"example.io"}, Resources: []string{"a"}, Verbs: []string{"get", "list"}},
+				},
+			},
+		},
+		{
+			name: "added and removed verbs",
+			old: map[string][]rbacv1.PolicyRule{
+				"edit": {{APIGroups: []string{"example.io"}, Resources: []string{"a"}, Verbs: []string{"create", "deletecollection"}}},
+			},
+			new: map[string][]rbacv1.PolicyRule{
+				"edit": {{APIGroups: []string{"example.io"}, Resources: []string{"a"}, Verbs: []string{"create", "approve"}}},
+			},
+			want: []RoleDiff{{
+				Name:    "edit",
+				Added:   []Tuple{{Group: "example.io", Resource: "a", Verb: "approve"}},
+				Removed: []Tuple{{Group: "example.io", Resource: "a", Verb: "deletecollection"}},
+			}},
+		},
+		{
+			name: "added and removed roles",
+			old: map[string][]rbacv1.PolicyRule{
+				"old": {{APIGroups: []string{"deckhouse.io"}, Resources: []string{"moduleconfigs"}, ResourceNames: []string{"foo"}, Verbs: []string{"get"}}},
+			},
+			new: map[string][]rbacv1.PolicyRule{
+				"new": {{APIGroups: []string{""}, Resources: []string{"pods/log", "pods"}, Verbs: []string{"get"}}},
+			},
+			want: []RoleDiff{
+				{
+					Name: "new",
+					Added: []Tuple{
+						{Resource: "pods", Verb: "get"},
+						{Resource: "pods/log", Verb: "get"},
+					},
+					Removed: []Tuple{},
+				},
+				{
+					Name:    "old",
+					Added:   []Tuple{},
+					Removed: []Tuple{{Group: "deckhouse.io", Resource: "moduleconfigs", Verb: "get", ResourceName: "foo"}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Compare(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compare() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTupleString(t *testing.T) {
+	tuple := Tuple{Group: "deckhouse.io", Resource: "moduleconfigs", Verb: "get", ResourceName: "foo"}
+	if got, want := tuple.String(), "get moduleconfigs.deckhouse.io/foo"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -15,14 +15,18 @@
 package doc
 
 import (
-	"os"
 	"sigs.k8s.io/yaml"
+	"slices"
 	"sort"
 
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/util/sets"
 
 	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/output"
+	"github.com/deckhouse/rbacgen/internal/engine/parser"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
 )
 
 type Docs struct {
@@ -40,6 +44,10 @@ type moduleDoc struct {
 	Subsystems   []string        `json:"subsystems"`
 	Capabilities capabilitiesDoc `json:"capabilities"`
 	Namespace    string          `json:"namespace"`
+	Weight       int             `json:"weight,omitempty"`
+	Description  string          `json:"description,omitempty"`
+	// Editions are set only if editions are configured
+	Editions []string `json:"editions,omitempty"`
 }
 type capabilitiesDoc struct {
 	Manage []capabilityDoc `json:"manage"`
@@ -48,6 +56,18 @@ type capabilitiesDoc struct {
 type capabilityDoc struct {
 	Name  string              `json:"name"`
 	Rules []rbacv1.PolicyRule `json:"rules"`
+	// APIVersions contain served versions of the CRDs the rules grant access to
+	APIVersions []apiVersionDoc `json:"apiVersions,omitempty"`
+	// Editions contain the capability with these rules
+	Editions []string `json:"editions,omitempty"`
+}
+type apiVersionDoc struct {
+	Group    string   `json:"group"`
+	Resource string   `json:"resource"`
+	Versions []string `json:"versions"`
+	Storage  string   `json:"storage,omitempty"`
+	// Deprecated contains warnings of the deprecated served versions by the version names
+	Deprecated map[string]string `json:"deprecated,omitempty"`
 }
 
 func New() *Docs {
@@ -57,21 +77,160 @@ func New() *Docs {
 	}
 }
 
-func (d *Docs) WriteTo(path string) error {
+// Load reads previously generated docs from the source
+func Load(src *source.Source, path string) (*Docs, error) {
+	raw, err := src.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := New()
+	if err = yaml.Unmarshal(raw, docs); err != nil {
+		return nil, err
+	}
+
+	for _, subsystem := range docs.Subsystems {
+		subsystem.namespacesSet = sets.New[string](subsystem.Namespaces...)
+	}
+
+	return docs, nil
+}
+
+// Roles returns rules of all documented capabilities by role name
+func (d *Docs) Roles() map[string][]rbacv1.PolicyRule {
+	roles := make(map[string][]rbacv1.PolicyRule)
+	for _, module := range d.Modules {
+		for _, capability := range module.Capabilities.Manage {
+			roles[capability.Name] = capability.Rules
+		}
+		for _, capability := range module.Capabilities.Use {
+			roles[capability.Name] = capability.Rules
+		}
+	}
+	return roles
+}
+
+// Merge replaces documented modules with the generated ones and keeps the rest of modules
+func (d *Docs) Merge(generated *Docs) {
+	for name, module := range generated.Modules {
+		d.Modules[name] = module
+	}
+
+	// remove regenerated modules from subsystems they left
+	for name, subsystem := range d.Subsystems {
+		subsystem.Modules = slices.DeleteFunc(subsystem.Modules, func(module string) bool {
+			regenerated, ok := generated.Modules[module]
+			return ok && !slices.Contains(regenerated.Subsystems, name)
+		})
+	}
+
+	for name, subsystem := range generated.Subsystems {
+		found, ok := d.Subsystems[name]
+		if !ok {
+			d.Subsystems[name] = subsystem
+			continue
+		}
+		for _, module := range subsystem.Modules {
+			if !slices.Contains(found.Modules, module) {
+				found.Modules = append(found.Modules, module)
+			}
+		}
+	}
+
+	for name, subsystem := range d.Subsystems {
+		if len(subsystem.Modules) == 0 {
+			delete(d.Subsystems, name)
+			continue
+		}
+		subsystem.namespacesSet = sets.New[string]()
+		for _, module := range subsystem.Modules {
+			if found, ok := d.Modules[module]; ok && found.Namespace != "" {
+				subsystem.namespacesSet.Insert(found.Namespace)
+			}
+		}
+	}
+}
+
+// AddEdition adds modules of the edition docs annotated with the edition, the namespace and subsystems
+// of the latest edition are kept, a capability with different rules is added for every edition it differs in
+func (d *Docs) AddEdition(edition string, docs *Docs) {
+	for name, module := range docs.Modules {
+		found, ok := d.Modules[name]
+		if !ok {
+			found = &moduleDoc{}
+			d.Modules[name] = found
+		}
+		found.Subsystems, found.Namespace = module.Subsystems, module.Namespace
+		found.Weight, found.Description = module.Weight, module.Description
+		found.Editions = append(found.Editions, edition)
+		found.Capabilities.Manage = addCapabilities(found.Capabilities.Manage, module.Capabilities.Manage, edition)
+		found.Capabilities.Use = addCapabilities(found.Capabilities.Use, module.Capabilities.Use, edition)
+	}
+
+	for name, subsystem := range docs.Subsystems {
+		found, ok := d.Subsystems[name]
+		if !ok {
+			found = &subsystemDoc{namespacesSet: sets.New[string]()}
+			d.Subsystems[name] = found
+		}
+		for _, module := range subsystem.Modules {
+			if !slices.Contains(found.Modules, module) {
+				found.Modules = append(found.Modules, module)
+			}
+		}
+		found.namespacesSet = found.namespacesSet.Union(subsystem.namespacesSet)
+	}
+}
+
+func addCapabilities(capabilities, added []capabilityDoc, edition string) []capabilityDoc {
+	for _, capability := range added {
+		idx := slices.IndexFunc(capabilities, func(found capabilityDoc) bool {
+			return found.Name == capability.Name && equality.Semantic.DeepEqual(found.Rules, capability.Rules)
+		})
+		if idx == -1 {
+			capabilities = append(capabilities, capabilityDoc{
+				Name:        capability.Name,
+				Rules:       capability.Rules,
+				APIVersions: capability.APIVersions,
+				Editions:    []string{edition},
+			})
+			continue
+		}
+		capabilities[idx].Editions = append(capabilities[idx].Editions, edition)
+	}
+	return capabilities
+}
+
+func (d *Docs) WriteTo(out output.Writer, path string) error {
+	marshaled, err := d.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return out.WriteFile(path, marshaled)
+}
+
+func (d *Docs) Marshal() ([]byte, error) {
 	for key, docs := range d.Subsystems {
 		if val, ok := d.Subsystems[key]; ok {
 			val.Namespaces = docs.namespacesSet.UnsortedList()
 			sort.Strings(val.Namespaces)
 			d.Subsystems[key] = val
 		}
+		// modules with the same weight keep the order they were added in
+		sort.SliceStable(docs.Modules, func(i, j int) bool {
+			return d.weight(docs.Modules[i]) < d.weight(docs.Modules[j])
+		})
 	}
 
-	marshaled, err := yaml.Marshal(d)
-	if err != nil {
-		return err
-	}
+	return yaml.Marshal(d)
+}
 
-	return os.WriteFile(path, marshaled, 0666)
+func (d *Docs) weight(module string) int {
+	if found, ok := d.Modules[module]; ok {
+		return found.Weight
+	}
+	return 0
 }
 
 func (d *Docs) AddSubsystem(module *models.Module) {
@@ -92,23 +251,70 @@ func (d *Docs) AddSubsystem(module *models.Module) {
 	}
 }
 
-func (d *Docs) AddModule(module *models.Module, manageRoles, useRoles []*rbacv1.ClusterRole) {
-	d.Modules[module.Definition.Name] = buildModuleDoc(module.Definition.Namespace, module.Definition.Subsystems, manageRoles, useRoles)
+// AddModule adds the module capabilities, versions are versions of the module CRDs by 'resource.group'
+func (d *Docs) AddModule(module *models.Module, manageRoles, useRoles []*rbacv1.ClusterRole, versions map[string][]parser.Version) {
+	d.Modules[module.Definition.Name] = buildModuleDoc(module.Definition, manageRoles, useRoles, versions)
 }
 
-func buildModuleDoc(namespace string, subsystems []string, manageRoles, useRoles []*rbacv1.ClusterRole) *moduleDoc {
-	docs := &moduleDoc{Subsystems: subsystems, Namespace: namespace}
+func buildModuleDoc(definition *models.Definition, manageRoles, useRoles []*rbacv1.ClusterRole, versions map[string][]parser.Version) *moduleDoc {
+	docs := &moduleDoc{
+		Subsystems:  definition.Subsystems,
+		Namespace:   definition.Namespace,
+		Weight:      definition.Weight,
+		Description: definition.Description(),
+	}
 	for _, role := range manageRoles {
 		docs.Capabilities.Manage = append(docs.Capabilities.Manage, capabilityDoc{
-			Name:  role.Name,
-			Rules: role.Rules,
+			Name:        role.Name,
+			Rules:       role.Rules,
+			APIVersions: buildAPIVersions(role.Rules, versions),
 		})
 	}
 	for _, role := range useRoles {
 		docs.Capabilities.Use = append(docs.Capabilities.Use, capabilityDoc{
-			Name:  role.Name,
-			Rules: role.Rules,
+			Name:        role.Name,
+			Rules:       role.Rules,
+			APIVersions: buildAPIVersions(role.Rules, versions),
 		})
 	}
 	return docs
 }
+
+// buildAPIVersions returns versions of the CRDs granted by the rules, resources without CRDs like moduleconfigs are skipped
+func buildAPIVersions(rules []rbacv1.PolicyRule, versions map[string][]parser.Version) []apiVersionDoc {
+	var docs []apiVersionDoc
+	for _, rule := range rules {
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				found, ok := versions[resource+"."+group]
+				if !ok || slices.ContainsFunc(docs, func(doc apiVersionDoc) bool { return doc.Group == group && doc.Resource == resource }) {
+					continue
+				}
+				doc := apiVersionDoc{Group: group, Resource: resource}
+				for _, version := range found {
+					if version.Storage {
+						doc.Storage = version.Name
+					}
+					if !version.Served {
+						continue
+					}
+					doc.Versions = append(doc.Versions, version.Name)
+					if version.Deprecated {
+						if doc.Deprecated == nil {
+							doc.Deprecated = make(map[string]string)
+						}
+						doc.Deprecated[version.Name] = version.Warning(group, resource)
+					}
+				}
+				docs = append(docs, doc)
+			}
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].Group != docs[j].Group {
+			return docs[i].Group < docs[j].Group
+		}
+		return docs[i].Resource < docs[j].Resource
+	})
+	return docs
+}
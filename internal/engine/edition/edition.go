@@ -0,0 +1,192 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package edition
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+)
+
+// Edition contains modules of the edition including modules inherited from the previous editions
+type Edition struct {
+	Name    string
+	Modules []*models.Module
+}
+
+// Owned returns modules defined in the edition root, roles of other modules are generated by the previous editions
+func (e *Edition) Owned() []*models.Module {
+	var owned []*models.Module
+	for _, module := range e.Modules {
+		if module.Edition == e.Name {
+			owned = append(owned, module)
+		}
+	}
+	return owned
+}
+
+// Find returns the module of the edition by the name or the path
+func (e *Edition) Find(name string) *models.Module {
+	for _, module := range e.Modules {
+		if module.Definition.Name == name || module.Path == name {
+			return module
+		}
+	}
+	return nil
+}
+
+// Resolve assigns modules to the edition roots and merges every module with the module of the same name
+// of the previous editions, modules outside of the edition roots are skipped,
+// modules found in other workdirs than the dir belong to the first edition
+func Resolve(dir string, editions []config.Edition, modules []*models.Module) ([]*Edition, error) {
+	if err := validate(editions); err != nil {
+		return nil, err
+	}
+
+	var assigned []*models.Module
+	for _, module := range modules {
+		if len(editions) != 0 && module.Root != "" && filepath.Clean(module.Root) != filepath.Clean(dir) {
+			module.Edition = editions[0].Name
+		} else {
+			module.Edition = editionOf(dir, editions, module.Path)
+		}
+		if module.Edition == "" {
+			slog.Warn("skip module outside of edition roots", "module", module.Definition.Name, "path", module.Path)
+			continue
+		}
+		assigned = append(assigned, module)
+	}
+
+	resolved := make([]*Edition, 0, len(editions))
+	var previous []*models.Module
+	for _, edition := range editions {
+		current := slices.Clone(previous)
+		for _, module := range assigned {
+			if module.Edition != edition.Name {
+				continue
+			}
+
+			idx := slices.IndexFunc(current, func(found *models.Module) bool {
+				return found.Definition.Name == module.Definition.Name
+			})
+			if idx == -1 {
+				current = append(current, module)
+				continue
+			}
+			if current[idx].Edition == edition.Name {
+				return nil, fmt.Errorf("module '%s' is defined twice in the edition '%s': '%s' and '%s'",
+					module.Definition.Name, edition.Name, current[idx].Path, module.Path)
+			}
+			slog.Debug("extend module", "module", module.Definition.Name, "edition", edition.Name, "base", current[idx].Path, "path", module.Path)
+			current[idx] = extend(current[idx], module)
+		}
+		resolved = append(resolved, &Edition{Name: edition.Name, Modules: current})
+		previous = current
+	}
+
+	return resolved, nil
+}
+
+func validate(editions []config.Edition) error {
+	var names []string
+	for _, edition := range editions {
+		if edition.Name == "" {
+			return errors.New("edition name is required")
+		}
+		if slices.Contains(names, edition.Name) {
+			return fmt.Errorf("edition '%s' is defined twice", edition.Name)
+		}
+		names = append(names, edition.Name)
+	}
+	return nil
+}
+
+// editionOf returns the edition with the deepest root containing the path
+func editionOf(dir string, editions []config.Edition, path string) string {
+	var found string
+	longest := -1
+	for _, edition := range editions {
+		root := filepath.Join(dir, edition.Path)
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		// a nested root is always longer than the root containing it
+		if len(root) > longest {
+			found, longest = edition.Name, len(root)
+		}
+	}
+	return found
+}
+
+// extend overrides the base module definition by the fields set in the module,
+// CRDs and allowed and forbidden resources of both modules are merged,
+// roles of the extended module are written to the module dir
+func extend(base, module *models.Module) *models.Module {
+	definition := *base.Definition
+	if module.Definition.Namespace != "" {
+		definition.Namespace = module.Definition.Namespace
+	}
+	if len(module.Definition.Subsystems) != 0 {
+		definition.Subsystems = module.Definition.Subsystems
+	}
+	if module.Definition.Weight != 0 {
+		definition.Weight = module.Definition.Weight
+	}
+	if module.Definition.Stage != "" {
+		definition.Stage = module.Definition.Stage
+	}
+	if len(module.Definition.Descriptions) != 0 {
+		definition.Descriptions = module.Definition.Descriptions
+	}
+	definition.ExcludeFromRBAC = definition.ExcludeFromRBAC || module.Definition.ExcludeFromRBAC
+
+	spec := &models.Spec{
+		CRDs:               union(base.Spec.CRDs, module.Spec.CRDs),
+		AllowedResources:   slices.Concat(base.Spec.AllowedResources, module.Spec.AllowedResources),
+		ForbiddenResources: union(base.Spec.ForbiddenResources, module.Spec.ForbiddenResources),
+		// subresources and policies of the extending module override the ones of the base
+		Subresources:     models.MergeSubresources(module.Spec.Subresources, base.Spec.Subresources),
+		ResourcePolicies: slices.Concat(module.Spec.ResourcePolicies, base.Spec.ResourcePolicies),
+		Origins:          maps.Clone(base.Spec.Origins),
+	}
+	if spec.Origins == nil {
+		spec.Origins = make(map[string]string)
+	}
+	for key, origin := range module.Spec.Origins {
+		if _, ok := spec.Origins[key]; !ok {
+			spec.Origins[key] = origin
+		}
+	}
+
+	return &models.Module{Path: module.Path, Root: module.Root, Source: module.Source, Edition: module.Edition, Definition: &definition, Spec: spec}
+}
+
+func union(base, values []string) []string {
+	merged := slices.Clone(base)
+	for _, value := range values {
+		if !slices.Contains(merged, value) {
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
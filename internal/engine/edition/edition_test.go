@@ -0,0 +1,192 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package edition
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+)
+
+var testEditions = []config.Edition{
+	{Name: "ce", Path: "."},
+	{Name: "ee", Path: "ee"},
+	{Name: "se", Path: "ee/se"},
+}
+
+// module returns the module found in the dir at the path relative to the workdir
+func module(path string, definition models.Definition, spec models.Spec) *models.Module {
+	return &models.Module{Path: filepath.Join("repo", filepath.FromSlash(path)), Root: "repo", Definition: &definition, Spec: &spec}
+}
+
+func TestEditionOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		editions []config.Edition
+		path     string
+		want     string
+	}{
+		{name: "root edition", editions: testEditions, path: "modules/foo", want: "ce"},
+		{name: "nested edition", editions: testEditions, path: "ee/modules/foo", want: "ee"},
+		{name: "deepest edition", editions: testEditions, path: "ee/se/modules/foo", want: "se"},
+		{name: "deepest edition listed first", editions: []config.Edition{{Name: "se", Path: "ee/se"}, {Name: "ee", Path: "ee"}}, path: "ee/se/modules/foo", want: "se"},
+		{name: "sibling with the root prefix", editions: []config.Edition{{Name: "ee", Path: "ee"}}, path: "eel/modules/foo"},
+		{name: "outside of roots", editions: []config.Edition{{Name: "ee", Path: "ee"}}, path: "modules/foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := editionOf("repo", tt.editions, filepath.Join("repo", filepath.FromSlash(tt.path))); got != tt.want {
+				t.Errorf("editionOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		modules []*models.Module
+		// want are modules of every edition by names
+		want    map[string]map[string]*models.Module
+		wantErr string
+	}{
+		{
+			name: "inheritance order",
+			modules: []*models.Module{
+				module("ee/se/modules/bar", models.Definition{Name: "bar"}, models.Spec{}),
+				module("ee/modules/baz", models.Definition{Name: "baz"}, models.Spec{}),
+				module("modules/foo", models.Definition{Name: "foo"}, models.Spec{}),
+			},
+			want: map[string]map[string]*models.Module{
+				"ce": {"foo": {Path: "repo/modules/foo", Edition: "ce"}},
+				"ee": {"foo": {Path: "repo/modules/foo", Edition: "ce"}, "baz": {Path: "repo/ee/modules/baz", Edition: "ee"}},
+				"se": {
+					"foo": {Path: "repo/modules/foo", Edition: "ce"},
+					"baz": {Path: "repo/ee/modules/baz", Edition: "ee"},
+					"bar": {Path: "repo/ee/se/modules/bar", Edition: "se"},
+				},
+			},
+		},
+		{
+			name: "namespace and subsystems override",
+			modules: []*models.Module{
+				module("modules/foo", models.Definition{Name: "foo", Namespace: "d8-foo", Subsystems: []string{"deckhouse"}, Weight: 10}, models.Spec{}),
+				module("ee/modules/foo", models.Definition{Name: "foo", Subsystems: []string{"network"}}, models.Spec{}),
+				module("ee/se/modules/foo", models.Definition{Name: "foo", Namespace: "d8-foo-se"}, models.Spec{}),
+			},
+			want: map[string]map[string]*models.Module{
+				"ce": {"foo": {Path: "repo/modules/foo", Edition: "ce", Definition: &models.Definition{Name: "foo", Namespace: "d8-foo", Subsystems: []string{"deckhouse"}, Weight: 10}}},
+				"ee": {"foo": {Path: "repo/ee/modules/foo", Edition: "ee", Definition: &models.Definition{Name: "foo", Namespace: "d8-foo", Subsystems: []string{"network"}, Weight: 10}}},
+				"se": {"foo": {Path: "repo/ee/se/modules/foo", Edition: "se", Definition: &models.Definition{Name: "foo", Namespace: "d8-foo-se", Subsystems: []string{"network"}, Weight: 10}}},
+			},
+		},
+		{
+			name: "CRDs and allowed and forbidden resources merge",
+			modules: []*models.Module{
+				module("modules/foo", models.Definition{Name: "foo"}, models.Spec{
+					CRDs:               []string{"modules/foo/crds/*.yaml"},
+					AllowedResources:   []models.Resource{{Group: "example.io", Resources: []string{"widgets"}}},
+					ForbiddenResources: []string{"secrets"},
+				}),
+				module("ee/modules/foo", models.Definition{Name: "foo"}, models.Spec{
+					CRDs:               []string{"modules/foo/crds/*.yaml", "ee/modules/foo/crds/*.yaml"},
+					AllowedResources:   []models.Resource{{Group: "example.io", Resources: []string{"gadgets"}}},
+					ForbiddenResources: []string{"secrets", "tokens"},
+				}),
+			},
+			want: map[string]map[string]*models.Module{
+				"ce": {"foo": {Path: "repo/modules/foo", Edition: "ce", Spec: &models.Spec{
+					CRDs:               []string{"modules/foo/crds/*.yaml"},
+					AllowedResources:   []models.Resource{{Group: "example.io", Resources: []string{"widgets"}}},
+					ForbiddenResources: []string{"secrets"},
+				}}},
+				"ee": {"foo": {Path: "repo/ee/modules/foo", Edition: "ee", Spec: &models.Spec{
+					CRDs: []string{"modules/foo/crds/*.yaml", "ee/modules/foo/crds/*.yaml"},
+					AllowedResources: []models.Resource{
+						{Group: "example.io", Resources: []string{"widgets"}},
+						{Group: "example.io", Resources: []string{"gadgets"}},
+					},
+					ForbiddenResources: []string{"secrets", "tokens"},
+					Origins:            map[string]string{},
+				}}},
+				"se": {"foo": {Path: "repo/ee/modules/foo", Edition: "ee"}},
+			},
+		},
+		{
+			name: "module outside of roots of the other workdir",
+			modules: []*models.Module{
+				{Path: "external/modules/foo", Root: "external", Definition: &models.Definition{Name: "foo"}, Spec: &models.Spec{}},
+			},
+			want: map[string]map[string]*models.Module{
+				"ce": {"foo": {Path: "external/modules/foo", Edition: "ce"}},
+				"ee": {"foo": {Path: "external/modules/foo", Edition: "ce"}},
+				"se": {"foo": {Path: "external/modules/foo", Edition: "ce"}},
+			},
+		},
+		{
+			name: "module defined twice in the edition",
+			modules: []*models.Module{
+				module("ee/modules/foo", models.Definition{Name: "foo"}, models.Spec{}),
+				module("ee/other/foo", models.Definition{Name: "foo"}, models.Spec{}),
+			},
+			wantErr: "module 'foo' is defined twice in the edition 'ee': 'repo/ee/modules/foo' and 'repo/ee/other/foo'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editions, err := Resolve("repo", testEditions, tt.modules)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Resolve() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(editions) != len(testEditions) {
+				t.Fatalf("Resolve() returned %d editions, want %d", len(editions), len(testEditions))
+			}
+			for idx, edition := range editions {
+				if edition.Name != testEditions[idx].Name {
+					t.Fatalf("Resolve() edition %d = %q, want %q", idx, edition.Name, testEditions[idx].Name)
+				}
+				want := tt.want[edition.Name]
+				if len(edition.Modules) != len(want) {
+					t.Fatalf("edition '%s' modules = %d, want %d", edition.Name, len(edition.Modules), len(want))
+				}
+				for name, wantModule := range want {
+					got := edition.Find(name)
+					if got == nil {
+						t.Fatalf("edition '%s' has no module '%s'", edition.Name, name)
+					}
+					if got.Path != filepath.FromSlash(wantModule.Path) || got.Edition != wantModule.Edition {
+						t.Errorf("edition '%s' module '%s' = %s of '%s', want %s of '%s'",
+							edition.Name, name, got.Path, got.Edition, wantModule.Path, wantModule.Edition)
+					}
+					if wantModule.Definition != nil && !reflect.DeepEqual(got.Definition, wantModule.Definition) {
+						t.Errorf("edition '%s' module '%s' definition = %+v, want %+v", edition.Name, name, got.Definition, wantModule.Definition)
+					}
+					if wantModule.Spec != nil && !reflect.DeepEqual(got.Spec, wantModule.Spec) {
+						t.Errorf("edition '%s' module '%s' spec = %+v, want %+v", edition.Name, name, got.Spec, wantModule.Spec)
+					}
+				}
+			}
+		})
+	}
+}
@@ -16,21 +16,403 @@ package engine
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strings"
 
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/doc"
+	"github.com/deckhouse/rbacgen/internal/engine/edition"
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/output"
 	"github.com/deckhouse/rbacgen/internal/engine/renderer"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
 	"github.com/deckhouse/rbacgen/internal/engine/walker"
 )
 
-func WalkAndRender(ctx context.Context, dir, docsPath string) error {
-	modules, err := walker.WalkModules(dir)
+type Options struct {
+	Dir string
+	// ExtraDirs are workdirs generated together with the dir, modules of all workdirs share the docs and the validation
+	ExtraDirs []string
+	// Source is the tree the dir is read from, like a git revision or an in-memory tree made by source.Memory,
+	// it is the local filesystem if it is not set, additional workdirs are read at the same revision
+	Source *source.Source
+	Config *config.Config
+	// DocsPath is optional, docs are not written if it is empty
+	DocsPath string
+
+	// Out receives roles, DocsOut receives docs
+	Out     output.Writer
+	DocsOut output.Writer
+
+	// Modules and Subsystems select modules to generate, all modules are generated if both are empty,
+	// the generated modules are merged into the existing docs
+	Modules    []string
+	Subsystems []string
+
+	// Prune removes files in templates dirs of the generated modules which were not generated by the run
+	Prune bool
+	// DryRun writes nothing, the files to prune are only listed
+	DryRun bool
+}
+
+func (o Options) selective() bool {
+	return len(o.Modules) != 0 || len(o.Subsystems) != 0
+}
+
+func (o Options) source() *source.Source {
+	if o.Source == nil {
+		return source.Disk()
+	}
+	return o.Source
+}
+
+func WalkAndRender(ctx context.Context, opts Options) (*Result, error) {
+	out := &output.Tracker{Writer: opts.Out}
+	out.Locator, _ = opts.Out.(output.Locator)
+	docsOut := &output.Tracker{Writer: opts.DocsOut}
+	docsOut.Locator, _ = opts.DocsOut.(output.Locator)
+	if opts.DryRun {
+		out.Writer, docsOut.Writer = output.NewMemory(), output.NewMemory()
+	}
+
+	run, err := walkAndRender(ctx, opts, out)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DocsPath != "" {
+		if err = run.docs.WriteTo(docsOut, opts.DocsPath); err != nil {
+			return nil, fmt.Errorf("failed to write docs '%s': %w", opts.DocsPath, err)
+		}
+	}
+
+	result := newResult(run.rendered, out, docsOut)
+	result.DryRun = opts.DryRun
+	result.Ignored = append(result.Ignored, run.ignored...)
+	for _, warning := range result.Warnings {
+		slog.WarnContext(ctx, warning)
+	}
+	if !opts.Prune {
+		return result, nil
+	}
+
+	if out.Locator == nil {
+		return nil, errors.New("pruning is supported only for roles written to dirs")
+	}
+	modules := renderedModules(run.rendered)
+	if result.Pruned, err = staleFiles(opts.Config, modules, out.Paths(), out.Locator, source.Disk()); err != nil {
+		return nil, err
+	}
+	slog.DebugContext(ctx, "found stale files", "files", result.Pruned, "dryRun", opts.DryRun)
+	if !opts.DryRun {
+		if err = prune(opts.Config, modules, result.Pruned, out.Locator); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Check renders roles and docs in memory and compares them with the existing files,
+// it writes a unified diff for every outdated or stale file and returns true if any drift is found
+func Check(ctx context.Context, opts Options, w io.Writer) (bool, error) {
+	mem := output.NewMemory()
+	run, err := walkAndRender(ctx, opts, mem)
+	if err != nil {
+		return false, err
+	}
+
+	if opts.DocsPath != "" {
+		if err = run.docs.WriteTo(mem, opts.DocsPath); err != nil {
+			return false, err
+		}
+	}
+
+	// generated files are compared with the files of the source they are generated from
+	var drift bool
+	for _, path := range mem.Paths() {
+		changed, err := diffFile(w, run.sourceOf(path), path, mem.Files[path])
+		if err != nil {
+			return false, err
+		}
+		drift = drift || changed
+	}
+
+	for _, module := range renderedModules(run.rendered) {
+		stale, err := staleFiles(opts.Config, []*models.Module{module}, mem.Paths(), output.Disk{}, module.Source)
+		if err != nil {
+			return false, err
+		}
+		for _, path := range stale {
+			if _, err = diffFile(w, module.Source, path, nil); err != nil {
+				return false, err
+			}
+			drift = true
+		}
+	}
+
+	return drift, nil
+}
+
+// rendering is the outcome of walking and rendering before writing docs
+type rendering struct {
+	rendered []*renderer.Roles
+	// unselected are roles of the modules which are not selected, they are built only to validate ownership
+	unselected []*renderer.Roles
+	docs       *doc.Docs
+	// ignored contains module.yaml files in ignored paths
+	ignored []string
+	// sources are trees of the workdirs, the first one is the tree of the dir
+	sources []*source.Source
+}
+
+// sourceOf returns the first source containing the path, paths outside of the sources are read from the local filesystem
+func (r *rendering) sourceOf(path string) *source.Source {
+	for _, src := range r.sources {
+		if src.Contains(path) {
+			return src
+		}
+	}
+	return source.Disk()
+}
+
+func walkAndRender(ctx context.Context, opts Options, out output.Writer) (*rendering, error) {
+	discovery, sources, err := discover(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// roles are written only if the rendered modules are valid
+	mem := output.NewMemory()
+	var run *rendering
+	if len(opts.Config.Editions) == 0 {
+		if err = validateNames(discovery.Modules); err != nil {
+			return nil, err
+		}
+		run, err = render(ctx, opts, discovery.Generated(), mem)
+	} else {
+		run, err = renderEditions(ctx, opts, discovery.Modules, mem)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// resources of the selected modules may be claimed by the modules which are not selected
+	if err = validateOwnership(slices.Concat(run.rendered, run.unselected)); err != nil {
+		return nil, err
+	}
+	if err = mem.Flush(out); err != nil {
+		return nil, err
+	}
+	run.ignored = discovery.Ignored
+	run.sources = sources
+
+	if !opts.selective() || opts.DocsPath == "" {
+		return run, nil
+	}
+
+	existing, err := doc.Load(run.sourceOf(opts.DocsPath), opts.DocsPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return run, nil
+		}
+		return nil, err
+	}
+	existing.Merge(run.docs)
+	run.docs = existing
+
+	return run, nil
+}
+
+// discover returns modules found in all workdirs of the options and the sources of the workdirs
+func discover(opts Options) (*walker.Discovery, []*source.Source, error) {
+	discovery := new(walker.Discovery)
+	sources := []*source.Source{opts.source()}
+	for idx, dir := range append([]string{opts.Dir}, opts.ExtraDirs...) {
+		// workdirs inside the tree of the dir are read from it, the revision belongs to the repository of the dir,
+		// so other workdirs are read from their working trees or bundles
+		src := sources[0]
+		if idx != 0 && !src.Contains(dir) {
+			var err error
+			if src, err = source.Open(dir, ""); err != nil {
+				return nil, nil, err
+			}
+			sources = append(sources, src)
+		}
+		found, err := walker.Discover(src, dir, opts.Config)
+		if err != nil {
+			return nil, nil, err
+		}
+		discovery.Modules = append(discovery.Modules, found.Modules...)
+		discovery.Ignored = append(discovery.Ignored, found.Ignored...)
+	}
+	return discovery, sources, nil
+}
+
+func render(ctx context.Context, opts Options, modules []*models.Module, out output.Writer) (*rendering, error) {
+	run := new(rendering)
+	if opts.selective() {
+		selected, err := selectModules(modules, opts.Modules, opts.Subsystems)
+		if err != nil {
+			return nil, err
+		}
+		if run.unselected, err = buildUnselected(ctx, opts.Config, modules, selected); err != nil {
+			return nil, err
+		}
+		modules = selected
+	}
+
+	docs, rendered, err := renderer.Render(ctx, opts.Config, modules, out)
+	if err != nil {
+		return nil, err
+	}
+	run.rendered, run.docs = rendered, docs
+
+	return run, nil
+}
+
+// buildUnselected builds roles of the modules which are not selected without rendering them
+func buildUnselected(ctx context.Context, cfg *config.Config, modules, selected []*models.Module) ([]*renderer.Roles, error) {
+	var built []*renderer.Roles
+	for _, module := range modules {
+		if slices.Contains(selected, module) {
+			continue
+		}
+		roles, err := renderer.Build(ctx, cfg, module)
+		if err != nil {
+			return nil, fmt.Errorf("module '%s' (%s): %w", module.Definition.Name, module.Path, err)
+		}
+		built = append(built, roles)
+	}
+	return built, nil
+}
+
+// renderEditions renders modules owned by every edition to their dirs,
+// docs contain modules of all editions annotated with the editions they exist in
+func renderEditions(ctx context.Context, opts Options, modules []*models.Module, out output.Writer) (*rendering, error) {
+	editions, err := edition.Resolve(opts.Dir, opts.Config.Editions, modules)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &rendering{docs: doc.New()}
+	// docs of the edition include modules inherited from the previous editions
+	inherited := doc.New()
+	for _, resolved := range editions {
+		slog.InfoContext(ctx, "render edition", "edition", resolved.Name)
+		owned := walker.Generated(resolved.Owned())
+		if opts.selective() {
+			// the selected modules may be owned by any of editions
+			selected := matchModules(owned, opts.Modules, opts.Subsystems)
+			unselected, err := buildUnselected(ctx, opts.Config, owned, selected)
+			if err != nil {
+				return nil, fmt.Errorf("edition '%s': %w", resolved.Name, err)
+			}
+			run.unselected = append(run.unselected, unselected...)
+			owned = selected
+		}
+		docs, rendered, err := renderer.Render(ctx, opts.Config, owned, out)
+		if err != nil {
+			return nil, fmt.Errorf("edition '%s': %w", resolved.Name, err)
+		}
+		run.rendered = append(run.rendered, rendered...)
+		inherited.Merge(docs)
+		run.docs.AddEdition(resolved.Name, inherited)
+	}
+
+	if err = checkSelected(renderedModules(run.rendered), opts.Modules); err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+func renderedModules(rendered []*renderer.Roles) []*models.Module {
+	modules := make([]*models.Module, 0, len(rendered))
+	for _, roles := range rendered {
+		modules = append(modules, roles.Module)
+	}
+	return modules
+}
+
+// selectModules keeps modules matched by any of the names or subsystems
+func selectModules(modules []*models.Module, names, subsystems []string) ([]*models.Module, error) {
+	selected := matchModules(modules, names, subsystems)
+	if err := checkSelected(selected, names); err != nil {
+		return nil, err
+	}
+	return selected, nil
+}
+
+func matchModules(modules []*models.Module, names, subsystems []string) []*models.Module {
+	var selected []*models.Module
+	for _, module := range modules {
+		if slices.Contains(names, module.Definition.Name) {
+			selected = append(selected, module)
+			continue
+		}
+		for _, subsystem := range module.Definition.Subsystems {
+			if slices.Contains(subsystems, subsystem) {
+				selected = append(selected, module)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// checkSelected returns an error if any of the names is not found in the selected modules
+func checkSelected(selected []*models.Module, names []string) error {
+	found := sets.New[string]()
+	for _, module := range selected {
+		found.Insert(module.Definition.Name)
+	}
+	if missing := sets.New[string](names...).Difference(found); missing.Len() != 0 {
+		return fmt.Errorf("modules not found: '%s'", strings.Join(sets.List(missing), "', '"))
+	}
+	return nil
+}
+
+func diffFile(w io.Writer, src *source.Source, path string, generated []byte) (bool, error) {
+	fromFile := path
+	existing, err := src.ReadFile(path)
 	if err != nil {
-		return err
+		if !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+		fromFile = "/dev/null"
 	}
 
-	docs, err := renderer.Render(ctx, modules)
+	toFile := path + " (generated)"
+	if generated == nil {
+		toFile = "/dev/null"
+	}
+
+	if string(existing) == string(generated) {
+		return false, nil
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(existing)),
+		B:        difflib.SplitLines(string(generated)),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	if _, err = fmt.Fprint(w, diff); err != nil {
+		return false, err
 	}
 
-	return docs.WriteTo(docsPath)
+	return true, nil
 }
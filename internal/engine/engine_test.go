@@ -0,0 +1,152 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/output"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+)
+
+const testCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.deckhouse.io
+spec:
+  group: deckhouse.io
+  scope: Namespaced
+  names:
+    plural: widgets
+    kind: Widget
+  versions:
+    - name: v1
+      served: true
+      storage: true
+      subresources:
+        status: {}
+`
+
+// testTree returns module files of the test tree keyed by paths relative to the workdir
+func testTree() map[string][]byte {
+	return map[string][]byte{
+		"modules/foo/module.yaml":      []byte("name: foo\nnamespace: d8-foo\nsubsystems:\n  - deckhouse\n"),
+		"modules/foo/crds/widget.yaml": []byte(testCRD),
+		"modules/bar/module.yaml":      []byte("name: bar\nsubsystems:\n  - network\n"),
+	}
+}
+
+// generate returns the tree with roles and docs generated for it
+func generate(t *testing.T, dir string, files map[string][]byte) map[string][]byte {
+	t.Helper()
+	src, err := source.Memory(dir, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := output.NewMemory()
+	opts := Options{Dir: dir, Source: src, Config: config.Default(), DocsPath: filepath.Join(dir, "docs.yaml"), Out: out, DocsOut: out}
+	if _, err = WalkAndRender(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	generated := maps.Clone(files)
+	for path, data := range out.Files {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated[filepath.ToSlash(rel)] = data
+	}
+	return generated
+}
+
+func TestCheck(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "repo")
+	generated := generate(t, dir, testTree())
+	for _, path := range []string{
+		"docs.yaml",
+		"modules/foo/templates/rbacv2/manage/view.yaml",
+		"modules/foo/templates/rbacv2/manage/edit.yaml",
+		"modules/foo/templates/rbacv2/use/view.yaml",
+		"modules/foo/templates/rbacv2/use/edit.yaml",
+		"modules/bar/templates/rbacv2/manage/view.yaml",
+	} {
+		if _, ok := generated[path]; !ok {
+			t.Fatalf("'%s' is not generated", path)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(files map[string][]byte)
+		wantDrift []string
+	}{
+		{
+			name:   "up to date",
+			modify: func(map[string][]byte) {},
+		},
+		{
+			name: "outdated role",
+			modify: func(files map[string][]byte) {
+				files["modules/foo/crds/widget.yaml"] = []byte(strings.ReplaceAll(testCRD, "widgets", "gadgets"))
+			},
+			wantDrift: []string{"modules/foo/templates/rbacv2/use/view.yaml (generated)", "-  - widgets", "+  - gadgets"},
+		},
+		{
+			name: "missing docs",
+			modify: func(files map[string][]byte) {
+				delete(files, "docs.yaml")
+			},
+			wantDrift: []string{"--- /dev/null", "docs.yaml (generated)"},
+		},
+		{
+			name: "stale role",
+			modify: func(files map[string][]byte) {
+				files["modules/bar/templates/rbacv2/use/view.yaml"] = []byte("kind: ClusterRole\n")
+			},
+			wantDrift: []string{"modules/bar/templates/rbacv2/use/view.yaml", "+++ /dev/null"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := maps.Clone(generated)
+			tt.modify(files)
+			src, err := source.Memory(dir, files)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := new(strings.Builder)
+			opts := Options{Dir: dir, Source: src, Config: config.Default(), DocsPath: filepath.Join(dir, "docs.yaml")}
+			drift, err := Check(context.Background(), opts, w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if drift != (len(tt.wantDrift) != 0) {
+				t.Fatalf("Check() drift = %v, diff:\n%s", drift, w)
+			}
+			for _, want := range tt.wantDrift {
+				if !strings.Contains(w.String(), want) {
+					t.Errorf("Check() diff does not contain %q:\n%s", want, w)
+				}
+			}
+		})
+	}
+}
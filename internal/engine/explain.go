@@ -0,0 +1,211 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/edition"
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/parser"
+	"github.com/deckhouse/rbacgen/internal/engine/renderer"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+	"github.com/deckhouse/rbacgen/internal/engine/walker"
+)
+
+// Explanation traces every filtering decision made for the module
+type Explanation struct {
+	Module *models.Module
+	// Skipped is true if roles are not generated for the module
+	Skipped   bool
+	Decisions []ExplainedDecision
+}
+
+// ExplainedDecision is a filtering decision with the rules the accepted resource ended up in
+type ExplainedDecision struct {
+	parser.Decision
+	Rules []RuleRef
+}
+
+// RuleRef points to a rule of a generated role
+type RuleRef struct {
+	Role  string
+	Index int
+	Verbs []string
+}
+
+// Explain traces the module found by the name or the path,
+// if editions are configured the module is merged with its counterparts of the previous editions
+func Explain(ctx context.Context, src *source.Source, cfg *config.Config, dir, name string) (*Explanation, error) {
+	discovery, err := walker.Discover(src, dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	module, err := findModule(dir, cfg, discovery.Modules, name)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := renderer.Build(ctx, cfg, module)
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := &Explanation{Module: module, Skipped: len(module.Definition.Subsystems) == 0 || module.Definition.ExcludeFromRBAC}
+	for _, decision := range roles.Parsed.Decisions {
+		explained := ExplainedDecision{Decision: decision}
+		if decision.Verdict == parser.VerdictAccepted {
+			explained.Rules = findRules(append(roles.Manage, roles.Use...), decision.Group, decision.Resource)
+		}
+		explanation.Decisions = append(explanation.Decisions, explained)
+	}
+
+	return explanation, nil
+}
+
+// findModule returns the module by the name or the path, the module of the latest edition is preferred
+func findModule(dir string, cfg *config.Config, modules []*models.Module, name string) (*models.Module, error) {
+	if len(cfg.Editions) == 0 {
+		idx := slices.IndexFunc(modules, func(module *models.Module) bool {
+			return module.Definition.Name == name || module.Path == name
+		})
+		if idx == -1 {
+			return nil, fmt.Errorf("module '%s' not found", name)
+		}
+		return modules[idx], nil
+	}
+
+	editions, err := edition.Resolve(dir, cfg.Editions, modules)
+	if err != nil {
+		return nil, err
+	}
+	for _, resolved := range slices.Backward(editions) {
+		if module := resolved.Find(name); module != nil {
+			return module, nil
+		}
+	}
+	return nil, fmt.Errorf("module '%s' not found", name)
+}
+
+func findRules(roles []*rbacv1.ClusterRole, group, resource string) []RuleRef {
+	var refs []RuleRef
+	for _, role := range roles {
+		for idx, rule := range role.Rules {
+			// rules of the resource subresources are named like 'resource/status'
+			if slices.Contains(rule.APIGroups, group) && slices.ContainsFunc(rule.Resources, func(found string) bool {
+				return found == resource || strings.HasPrefix(found, resource+"/")
+			}) {
+				refs = append(refs, RuleRef{Role: role.Name, Index: idx, Verbs: rule.Verbs})
+			}
+		}
+	}
+	return refs
+}
+
+func PrintExplanation(w io.Writer, explanation *Explanation) error {
+	module := explanation.Module
+	if _, err := fmt.Fprintf(w, "module '%s' (%s)\n", module.Definition.Name, module.Path); err != nil {
+		return err
+	}
+	if module.Edition != "" {
+		if _, err := fmt.Fprintf(w, "  edition '%s'\n", module.Edition); err != nil {
+			return err
+		}
+	}
+	switch {
+	case explanation.Skipped && module.Definition.ExcludeFromRBAC:
+		if _, err := fmt.Fprintln(w, "  skipped, the module is excluded from RBAC, roles are not generated"); err != nil {
+			return err
+		}
+	case explanation.Skipped:
+		if _, err := fmt.Fprintln(w, "  skipped, the module has no subsystems, roles are not generated"); err != nil {
+			return err
+		}
+	}
+	if len(explanation.Decisions) == 0 {
+		_, err := fmt.Fprintln(w, "  no CRD files found")
+		return err
+	}
+
+	for _, decision := range explanation.Decisions {
+		if decision.Verdict == parser.VerdictDocFile {
+			if _, err := fmt.Fprintf(w, "  %s: %s\n", decision.File, decision.Verdict); err != nil {
+				return err
+			}
+			continue
+		}
+		var origin string
+		if decision.Origin != "" {
+			origin = fmt.Sprintf(", declared in '%s'", decision.Origin)
+		}
+		if _, err := fmt.Fprintf(w, "  %s#%d %s.%s (%s): %s%s\n",
+			decision.File, decision.Document, decision.Resource, decision.Group, decision.Scope, decision.Verdict, origin); err != nil {
+			return err
+		}
+		if len(decision.Versions) != 0 {
+			if _, err := fmt.Fprintf(w, "    versions: %s\n", formatVersions(decision.Versions)); err != nil {
+				return err
+			}
+		}
+		for _, version := range decision.Versions {
+			if version.Served && version.Deprecated {
+				if _, err := fmt.Fprintf(w, "    warning: %s\n", version.Warning(decision.Group, decision.Resource)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, rule := range decision.Rules {
+			if _, err := fmt.Fprintf(w, "    %s rule %d: %s\n", rule.Role, rule.Index, strings.Join(rule.Verbs, ", ")); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// formatVersions lists the versions with their flags, like 'v1 (served, storage), v1beta1 (served, deprecated)'
+func formatVersions(versions []parser.Version) string {
+	formatted := make([]string, 0, len(versions))
+	for _, version := range versions {
+		var flags []string
+		if version.Served {
+			flags = append(flags, "served")
+		}
+		if version.Storage {
+			flags = append(flags, "storage")
+		}
+		if version.Deprecated {
+			flags = append(flags, "deprecated")
+		}
+		for _, subresource := range version.Subresources {
+			flags = append(flags, subresource)
+		}
+		if len(flags) == 0 {
+			formatted = append(formatted, version.Name)
+			continue
+		}
+		formatted = append(formatted, fmt.Sprintf("%s (%s)", version.Name, strings.Join(flags, ", ")))
+	}
+	return strings.Join(formatted, ", ")
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/edition"
+	"github.com/deckhouse/rbacgen/internal/engine/parser"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+	"github.com/deckhouse/rbacgen/internal/engine/walker"
+)
+
+// ModuleInfo describes a module found by the walker
+type ModuleInfo struct {
+	Name       string        `json:"name"`
+	Path       string        `json:"path"`
+	Namespace  string        `json:"namespace"`
+	Edition    string        `json:"edition,omitempty"`
+	Subsystems []string      `json:"subsystems"`
+	CRDs       []parser.Glob `json:"crds"`
+	Weight     int           `json:"weight,omitempty"`
+	// Skipped is true if roles are not generated for the module
+	Skipped bool `json:"skipped"`
+	// Excluded is true if the module is excluded from RBAC generation by its module.yaml
+	Excluded bool `json:"excluded,omitempty"`
+	// Ignored is true if the module is in a path ignored by .gitignore files or the config globs, it is not parsed
+	Ignored bool `json:"ignored"`
+}
+
+// List returns all modules found in the dir of the source
+func List(src *source.Source, cfg *config.Config, dir string) ([]ModuleInfo, error) {
+	discovery, err := walker.Discover(src, dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Editions) != 0 {
+		// modules are assigned to editions, they are not merged to show their own definitions
+		if _, err = edition.Resolve(dir, cfg.Editions, discovery.Modules); err != nil {
+			return nil, err
+		}
+	}
+
+	infos := make([]ModuleInfo, 0, len(discovery.Modules)+len(discovery.Ignored))
+	for _, module := range discovery.Modules {
+		globs, err := parser.Globs(module)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, ModuleInfo{
+			Name:       module.Definition.Name,
+			Path:       module.Path,
+			Namespace:  module.Definition.Namespace,
+			Edition:    module.Edition,
+			Subsystems: module.Definition.Subsystems,
+			CRDs:       globs,
+			Weight:     module.Definition.Weight,
+			Skipped:    len(module.Definition.Subsystems) == 0 || module.Definition.ExcludeFromRBAC,
+			Excluded:   module.Definition.ExcludeFromRBAC,
+		})
+	}
+	for _, path := range discovery.Ignored {
+		infos = append(infos, ModuleInfo{Path: filepath.Dir(path), Skipped: true, Ignored: true})
+	}
+
+	return infos, nil
+}
+
+func PrintJSON(w io.Writer, infos []ModuleInfo) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(infos)
+}
+
+func PrintTable(w io.Writer, infos []ModuleInfo) error {
+	editions := slices.ContainsFunc(infos, func(info ModuleInfo) bool {
+		return info.Edition != ""
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "NAME\tNAMESPACE\tSUBSYSTEMS\tPATH\tCRDS\tFILES\tSTATUS"
+	if editions {
+		header += "\tEDITION"
+	}
+	fmt.Fprintln(tw, header)
+	for _, info := range infos {
+		var patterns []string
+		for _, glob := range info.CRDs {
+			patterns = append(patterns, glob.Pattern)
+		}
+		files := len(parser.Files(info.CRDs))
+		status := "generated"
+		switch {
+		case info.Ignored:
+			status = "ignored"
+		case info.Excluded:
+			status = "excluded"
+		case info.Skipped:
+			status = "skipped(no subsystems)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s",
+			orNone(info.Name), orNone(info.Namespace), orNone(strings.Join(info.Subsystems, ",")), info.Path, orNone(strings.Join(patterns, ",")), files, status)
+		if editions {
+			fmt.Fprintf(tw, "\t%s", orNone(info.Edition))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -14,28 +14,254 @@
 
 package models
 
+import (
+	"slices"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+)
+
 const (
 	DefinitionFile = "module.yaml"
 	SpecFile       = "rbac.yaml"
+
+	// AllResources in resources of a group matches every resource of the group
+	AllResources = "all"
+	// AnyGroup is the group of the subresources applied to resources of every group
+	AnyGroup = "*"
 )
 
 type Module struct {
-	Path       string
+	Path string
+	// Root is the workdir the module is found in, relative CRDs globs of rbac.yaml are resolved against it
+	Root string
+	// Source is the tree the module files are read from
+	Source *source.Source
+	// Edition is the name of the edition the module dir belongs to, it is empty if editions are not configured
+	Edition    string
 	Definition *Definition
 	Spec       *Spec
 }
 
 type Definition struct {
-	Name       string
-	Namespace  string
-	Subsystems []string
+	Name string `yaml:"name"`
+	// Weight orders modules in docs
+	Weight     int      `yaml:"weight,omitempty"`
+	Stage      string   `yaml:"stage,omitempty"`
+	Critical   bool     `yaml:"critical,omitempty"`
+	Namespace  string   `yaml:"namespace,omitempty"`
+	Subsystems []string `yaml:"subsystems,omitempty"`
+	Tags       []string `yaml:"tags,omitempty"`
+	// Descriptions are keyed by language, like en or ru
+	Descriptions map[string]string `yaml:"descriptions,omitempty"`
+	Disable      *Disable          `yaml:"disable,omitempty"`
+	Requirements *Requirements     `yaml:"requirements,omitempty"`
+	// ExcludeFromRBAC disables roles generation for the module
+	ExcludeFromRBAC bool `yaml:"excludeFromRBAC,omitempty"`
+}
+
+// Description returns the english description of the module
+func (d *Definition) Description() string {
+	return d.Descriptions["en"]
+}
+
+// Disable describes how the module disabling is confirmed
+type Disable struct {
+	Confirmation bool   `yaml:"confirmation,omitempty"`
+	Message      string `yaml:"message,omitempty"`
+}
+
+// Requirements contains version constraints of the module
+type Requirements struct {
+	Kubernetes string `yaml:"kubernetes,omitempty"`
+	Deckhouse  string `yaml:"deckhouse,omitempty"`
+	// Modules are constraints of other modules versions by their names
+	Modules map[string]string `yaml:"modules,omitempty"`
 }
+
 type Spec struct {
-	CRDs               []string   `yaml:"crds"`
-	AllowedResources   []Resource `yaml:"allowedResources"`
-	ForbiddenResources []string   `yaml:"forbiddenResources"`
+	// Extends are paths of spec fragments merged into the spec, they are relative to the file extending them
+	Extends            Paths      `yaml:"extends,omitempty"`
+	CRDs               []string   `yaml:"crds,omitempty"`
+	AllowedResources   []Resource `yaml:"allowedResources,omitempty"`
+	ForbiddenResources []string   `yaml:"forbiddenResources,omitempty"`
+	// SharedResources may be claimed by other modules which share them too
+	SharedResources []Resource `yaml:"sharedResources,omitempty"`
+	// Subresources override verbs of the status and scale subresources or add custom ones
+	Subresources []Subresource `yaml:"subresources,omitempty"`
+	// ResourcePolicies override verbs of the resources, the first policy matching the resource wins
+	ResourcePolicies []ResourcePolicy `yaml:"resourcePolicies,omitempty"`
+	// Origins are the files allowed and forbidden resources are declared in, the first declaration wins
+	Origins map[string]string `yaml:"-"`
 }
 type Resource struct {
 	Group     string   `yaml:"group"`
 	Resources []string `yaml:"resources"`
 }
+
+// ResourcePolicy overrides verbs of the resources of the group, 'all' matches all resources of the group,
+// omitted verbs keep the defaults, empty ones disable the rule
+type ResourcePolicy struct {
+	Group     string   `yaml:"group"`
+	Resources []string `yaml:"resources"`
+	// View are verbs of the viewer roles
+	View []string `yaml:"view,omitempty"`
+	// Edit are verbs of the manager roles
+	Edit []string `yaml:"edit,omitempty"`
+}
+
+// Matches returns true if the policy applies to the resource of the group
+func (p ResourcePolicy) Matches(group, resource string) bool {
+	return p.Group == group && (slices.Contains(p.Resources, resource) || slices.Contains(p.Resources, AllResources))
+}
+
+// Subresource configures rules of the subresource, omitted verbs keep the defaults, empty ones disable the rule
+type Subresource struct {
+	Name string `yaml:"name"`
+	// Group is the group of the resources, it is empty for the core group, AnyGroup entries apply to every group
+	// which has no entry of the subresource
+	Group string `yaml:"group,omitempty"`
+	// Resources of the group have the subresource even if the module has no CRDs declaring it,
+	// like subresources served by aggregated API servers
+	Resources []string `yaml:"resources,omitempty"`
+	// Scope of the resources without CRDs in the module, Cluster resources get manage rules, Namespaced ones get use rules,
+	// it is Namespaced by default
+	Scope string `yaml:"scope,omitempty"`
+	// View are verbs of the viewer roles
+	View []string `yaml:"view,omitempty"`
+	// Edit are verbs of the manager roles
+	Edit []string `yaml:"edit,omitempty"`
+}
+
+// SetOrigins records the file as the origin of the resources declared by the spec which have no origin yet
+func (s *Spec) SetOrigins(path string) {
+	if s.Origins == nil {
+		s.Origins = make(map[string]string)
+	}
+	for _, allowed := range s.AllowedResources {
+		for _, resource := range allowed.Resources {
+			if key := allowedKey(allowed.Group, resource); s.Origins[key] == "" {
+				s.Origins[key] = path
+			}
+		}
+	}
+	for _, resource := range s.ForbiddenResources {
+		if key := forbiddenKey(resource); s.Origins[key] == "" {
+			s.Origins[key] = path
+		}
+	}
+}
+
+// AllowedOrigin returns the file the allowed resource of the group is declared in
+func (s *Spec) AllowedOrigin(group, resource string) string {
+	return s.Origins[allowedKey(group, resource)]
+}
+
+// ForbiddenOrigin returns the file the forbidden resource is declared in
+func (s *Spec) ForbiddenOrigin(resource string) string {
+	return s.Origins[forbiddenKey(resource)]
+}
+
+// Merge adds the CRDs, the resources and the policies of the fragment which are not in the spec,
+// allowed and shared resources are merged by groups
+func (s *Spec) Merge(fragment *Spec) {
+	s.CRDs = union(s.CRDs, fragment.CRDs)
+	s.AllowedResources = mergeResources(s.AllowedResources, fragment.AllowedResources)
+	s.ForbiddenResources = union(s.ForbiddenResources, fragment.ForbiddenResources)
+	s.SharedResources = mergeResources(s.SharedResources, fragment.SharedResources)
+	s.Subresources = MergeSubresources(s.Subresources, fragment.Subresources)
+	// policies of the spec are matched before the policies of the fragment
+	s.ResourcePolicies = append(s.ResourcePolicies, fragment.ResourcePolicies...)
+	if s.Origins == nil {
+		s.Origins = make(map[string]string)
+	}
+	for key, origin := range fragment.Origins {
+		if s.Origins[key] == "" {
+			s.Origins[key] = origin
+		}
+	}
+}
+
+func allowedKey(group, resource string) string {
+	return "allowed/" + resource + "." + group
+}
+
+func forbiddenKey(resource string) string {
+	return "forbidden/" + resource
+}
+
+func mergeResources(base, values []Resource) []Resource {
+	merged := slices.Clone(base)
+	for _, value := range values {
+		idx := slices.IndexFunc(merged, func(resource Resource) bool {
+			return resource.Group == value.Group
+		})
+		if idx == -1 {
+			merged = append(merged, Resource{Group: value.Group, Resources: slices.Clone(value.Resources)})
+			continue
+		}
+		merged[idx].Resources = union(merged[idx].Resources, value.Resources)
+	}
+	return merged
+}
+
+// MergeSubresources adds the subresources which are not in the base, subresources with the same name and group are merged,
+// resources are united and verbs and the scope of the base win
+func MergeSubresources(base, values []Subresource) []Subresource {
+	merged := slices.Clone(base)
+	for _, value := range values {
+		idx := slices.IndexFunc(merged, func(subresource Subresource) bool {
+			return subresource.Name == value.Name && subresource.Group == value.Group
+		})
+		if idx == -1 {
+			merged = append(merged, value)
+			continue
+		}
+		merged[idx].Resources = union(merged[idx].Resources, value.Resources)
+		if merged[idx].View == nil {
+			merged[idx].View = value.View
+		}
+		if merged[idx].Edit == nil {
+			merged[idx].Edit = value.Edit
+		}
+		if merged[idx].Scope == "" {
+			merged[idx].Scope = value.Scope
+		}
+	}
+	return merged
+}
+
+func union(base, values []string) []string {
+	merged := slices.Clone(base)
+	for _, value := range values {
+		if !slices.Contains(merged, value) {
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
+
+// Paths is a list of paths, a single path may be written as a string
+type Paths []string
+
+func (p *Paths) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = Paths{node.Value}
+		return nil
+	}
+	var paths []string
+	if err := node.Decode(&paths); err != nil {
+		return err
+	}
+	*p = paths
+	return nil
+}
+
+// JSONSchema returns the schema of the path or the list of paths
+func (Paths) JSONSchema() map[string]any {
+	return map[string]any{"oneOf": []any{
+		map[string]any{"type": "string"},
+		map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+	}}
+}
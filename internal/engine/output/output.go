@@ -0,0 +1,188 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Writer receives generated files
+type Writer interface {
+	WriteFile(path string, data []byte) error
+}
+
+// Locator is implemented by writers which store files on the local filesystem
+type Locator interface {
+	// Locate returns the location of the generated file on the local filesystem
+	Locate(path string) string
+}
+
+// Disk writes files to the local filesystem
+type Disk struct{}
+
+func (Disk) Locate(path string) string {
+	return path
+}
+
+func (Disk) WriteFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Dir mirrors the layout relative to the base dir into the root dir,
+// files outside the base dir are placed into the root dir by their names
+type Dir struct {
+	Root string
+	Base string
+	// Extra are other base dirs, their layouts are mirrored into the root subdirs named after them
+	Extra []string
+}
+
+// NewDir returns the dir output, it fails if an extra base dir would be mirrored into the same subdir
+// as another extra base dir or as an entry of the base dir
+func NewDir(root, base string, extra []string) (Dir, error) {
+	names := make(map[string]string, len(extra))
+	for _, dir := range extra {
+		name := extraName(dir)
+		other, ok := names[name]
+		if _, err := os.Stat(filepath.Join(base, name)); err == nil {
+			other, ok = base, true
+		}
+		if ok {
+			return Dir{}, fmt.Errorf("workdirs '%s' and '%s' would both be written into '%s'", other, dir, filepath.Join(root, name))
+		}
+		names[name] = dir
+	}
+	return Dir{Root: root, Base: base, Extra: extra}, nil
+}
+
+func (d Dir) WriteFile(path string, data []byte) error {
+	return Disk{}.WriteFile(d.Locate(path), data)
+}
+
+func (d Dir) Locate(path string) string {
+	if rel, ok := relative(d.Base, path); ok {
+		return filepath.Join(d.Root, rel)
+	}
+	for _, base := range d.Extra {
+		if rel, ok := relative(base, path); ok {
+			return filepath.Join(d.Root, extraName(base), rel)
+		}
+	}
+	return filepath.Join(d.Root, filepath.Base(path))
+}
+
+// extraName returns the name of the root subdir the extra base dir is mirrored into
+func extraName(base string) string {
+	return filepath.Base(filepath.Clean(base))
+}
+
+// relative returns the path relative to the base if the path is inside the base
+func relative(base, path string) (string, bool) {
+	rel, err := filepath.Rel(base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// Stream writes all files as one multi-document yaml
+type Stream struct {
+	W io.Writer
+}
+
+func (s Stream) WriteFile(path string, data []byte) error {
+	_, err := fmt.Fprintf(s.W, "---\n# Source: %s\n%s", filepath.ToSlash(path), data)
+	return err
+}
+
+// Tracker records paths of the files written by the wrapped writer
+type Tracker struct {
+	Writer
+	// Locator is optional, it is used to find existing files, files with the same content are not rewritten
+	Locator   Locator
+	Written   []string
+	Unchanged []string
+}
+
+func (t *Tracker) WriteFile(path string, data []byte) error {
+	if t.Locator != nil {
+		if existing, err := os.ReadFile(t.Locator.Locate(path)); err == nil && bytes.Equal(existing, data) {
+			slog.Debug("file is unchanged", "file", path)
+			t.Unchanged = append(t.Unchanged, path)
+			return nil
+		}
+	}
+	if err := t.Writer.WriteFile(path, data); err != nil {
+		return err
+	}
+	slog.Debug("file is written", "file", path)
+	t.Written = append(t.Written, path)
+	return nil
+}
+
+// Paths returns paths of all the written and unchanged files
+func (t *Tracker) Paths() []string {
+	return append(slices.Clone(t.Written), t.Unchanged...)
+}
+
+// Memory keeps files in memory, it is used to compare generated files with existing ones
+type Memory struct {
+	Files map[string][]byte
+	// order keeps paths in the order they were first written
+	order []string
+}
+
+func NewMemory() *Memory {
+	return &Memory{Files: make(map[string][]byte)}
+}
+
+func (m *Memory) WriteFile(path string, data []byte) error {
+	if _, ok := m.Files[path]; !ok {
+		m.order = append(m.order, path)
+	}
+	m.Files[path] = data
+	return nil
+}
+
+// Flush writes the stored files to the writer in the order they were written
+func (m *Memory) Flush(out Writer) error {
+	for _, path := range m.order {
+		if err := out.WriteFile(path, m.Files[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Paths returns sorted paths of the stored files
+func (m *Memory) Paths() []string {
+	paths := make([]string, 0, len(m.Files))
+	for path := range m.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewDir(t *testing.T) {
+	base := t.TempDir()
+	if err := os.Mkdir(filepath.Join(base, "modules"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		extra   []string
+		wantErr string
+	}{
+		{
+			name:  "distinct names",
+			extra: []string{"../a/external", "../b/other"},
+		},
+		{
+			name:    "same names",
+			extra:   []string{"../a/external", "../b/external"},
+			wantErr: "workdirs '../a/external' and '../b/external' would both be written into 'out/external'",
+		},
+		{
+			name:    "name of the base entry",
+			extra:   []string{"../a/modules"},
+			wantErr: "workdirs '" + base + "' and '../a/modules' would both be written into 'out/modules'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := NewDir("out", base, tt.extra)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("NewDir() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewDir() error = %v", err)
+			}
+			if got, want := dir.Locate("../b/other/foo/module.yaml"), filepath.Join("out", "other", "foo", "module.yaml"); got != want {
+				t.Errorf("Locate() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -19,73 +19,163 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"log/slog"
 	"slices"
 	"strings"
 
 	apiextensionv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apimachineryYaml "k8s.io/apimachinery/pkg/util/yaml"
 
+	"github.com/deckhouse/rbacgen/internal/engine/config"
 	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
 )
 
 const (
 	customResourceDefinitionKind = "CustomResourceDefinition"
+	// legacyAPIVersion CRDs may declare the single version in spec.version
+	legacyAPIVersion = "apiextensions.k8s.io/v1beta1"
 
 	scopeNamespaced = "Namespaced"
 	scopeCluster    = "Cluster"
 
-	deckhouseGroup = "deckhouse.io"
-
-	allResources = "all"
+	SubresourceStatus = "status"
+	SubresourceScale  = "scale"
 )
 
 type parser struct {
 	buffer []byte
 }
 
+// Glob is a CRDs glob with the files matched by it, files matched by the negated glob are excluded from all globs
+type Glob struct {
+	Pattern string   `json:"pattern"`
+	Files   []string `json:"files"`
+	Negated bool     `json:"negated,omitempty"`
+}
+
+// Files returns files matched by the globs except the files matched by the negated ones
+func Files(globs []Glob) []string {
+	excluded := make(map[string]bool)
+	for _, glob := range globs {
+		if glob.Negated {
+			for _, file := range glob.Files {
+				excluded[file] = true
+			}
+		}
+	}
+
+	var files []string
+	for _, glob := range globs {
+		if glob.Negated {
+			continue
+		}
+		for _, file := range glob.Files {
+			if !excluded[file] && !slices.Contains(files, file) {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// Verdict explains why a CRD is accepted or rejected
+type Verdict string
+
+const (
+	VerdictAccepted        Verdict = "accepted"
+	VerdictDocFile         Verdict = "skipped, doc file"
+	VerdictForbidden       Verdict = "rejected, forbidden resource"
+	VerdictGroupNotAllowed Verdict = "rejected, group is not allowed"
+	VerdictNotServed       Verdict = "rejected, no served versions"
+)
+
+// Version is a version of the CRD
+type Version struct {
+	Name       string `json:"name"`
+	Served     bool   `json:"served"`
+	Storage    bool   `json:"storage"`
+	Deprecated bool   `json:"deprecated,omitempty"`
+	// DeprecationWarning is returned to API clients using the deprecated version, it is optional
+	DeprecationWarning string `json:"deprecationWarning,omitempty"`
+	// Subresources are names of the subresources enabled for the version, like status and scale
+	Subresources []string `json:"subresources,omitempty"`
+}
+
+// Warning returns the warning of the deprecated version, the default one of the API server is used if the CRD does not set it
+func (v Version) Warning(group, resource string) string {
+	if v.DeprecationWarning != "" {
+		return v.DeprecationWarning
+	}
+	return fmt.Sprintf("%s/%s %s is deprecated", group, v.Name, resource)
+}
+
+// Decision is the filtering decision for a CRD file or a document in it
+type Decision struct {
+	File string `json:"file"`
+	// Document is the index of the document in the file
+	Document int     `json:"document"`
+	Group    string  `json:"group,omitempty"`
+	Resource string  `json:"resource,omitempty"`
+	Scope    string  `json:"scope,omitempty"`
+	Verdict  Verdict `json:"verdict"`
+	// Origin is the rbac.yaml or the fragment file declaring the allowed or forbidden resource the verdict is made by
+	Origin   string    `json:"origin,omitempty"`
+	Versions []Version `json:"versions,omitempty"`
+}
+
 type ParsedCRDs struct {
 	Cluster    map[string][]string
 	Namespaced map[string][]string
+	Decisions  []Decision
+	// Globs contains the expanded CRDs globs
+	Globs []Glob
+	// Versions contains versions of the accepted resources by 'resource.group'
+	Versions map[string][]Version
 }
 
-func Parse(ctx context.Context, module *models.Module) (*ParsedCRDs, error) {
+func Parse(ctx context.Context, cfg *config.Config, module *models.Module) (*ParsedCRDs, error) {
 	result := &ParsedCRDs{
 		Cluster:    make(map[string][]string),
 		Namespaced: make(map[string][]string),
+		Versions:   make(map[string][]Version),
 	}
 
 	if module.Spec == nil {
 		return result, nil
 	}
 
-	var crds []string
-	for _, dir := range module.Spec.CRDs {
-		tmp, err := filepath.Glob(dir)
-		if err != nil {
-			return nil, err
-		}
-		crds = append(crds, tmp...)
+	globs, err := Globs(module)
+	if err != nil {
+		return nil, err
 	}
+	result.Globs = globs
 
-	for _, crd := range crds {
+	for _, crd := range Files(globs) {
 		if strings.Contains(crd, "doc-") {
+			slog.DebugContext(ctx, "skip doc file", "module", module.Definition.Name, "file", crd)
+			result.Decisions = append(result.Decisions, Decision{File: crd, Verdict: VerdictDocFile})
 			continue
 		}
+		slog.DebugContext(ctx, "parse CRD file", "module", module.Definition.Name, "file", crd)
 		p := &parser{buffer: make([]byte, 1*1024*1024)}
-		parsed, err := p.processFile(ctx, crd, module.Spec)
+		decisions, err := p.processFile(ctx, sourceOf(module), crd, cfg, module.Spec)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to parse CRD file '%s': %w", crd, err)
 		}
-		if len(parsed) != 0 {
-			for _, parsedCRD := range parsed {
-				if parsedCRD.Spec.Scope == scopeCluster {
-					result.Cluster[parsedCRD.Spec.Group] = append(result.Cluster[parsedCRD.Spec.Group], parsedCRD.Spec.Names.Plural)
-				}
-				if parsedCRD.Spec.Scope == scopeNamespaced {
-					result.Namespaced[parsedCRD.Spec.Group] = append(result.Namespaced[parsedCRD.Spec.Group], parsedCRD.Spec.Names.Plural)
-				}
+		for _, decision := range decisions {
+			slog.DebugContext(ctx, "filter CRD", "module", module.Definition.Name, "file", crd,
+				"group", decision.Group, "resource", decision.Resource, "scope", decision.Scope, "verdict", decision.Verdict)
+			result.Decisions = append(result.Decisions, decision)
+			if decision.Verdict != VerdictAccepted {
+				continue
+			}
+			result.Versions[decision.Resource+"."+decision.Group] = decision.Versions
+			if decision.Scope == scopeCluster {
+				result.Cluster[decision.Group] = append(result.Cluster[decision.Group], decision.Resource)
+			}
+			if decision.Scope == scopeNamespaced {
+				result.Namespaced[decision.Group] = append(result.Namespaced[decision.Group], decision.Resource)
 			}
 		}
 	}
@@ -93,15 +183,42 @@ func Parse(ctx context.Context, module *models.Module) (*ParsedCRDs, error) {
 	return result, nil
 }
 
-func (p *parser) processFile(ctx context.Context, path string, spec *models.Spec) (crds []*apiextensionv1.CustomResourceDefinition, err error) {
-	file, err := os.Open(path)
+// Globs expands CRDs globs of the module
+func Globs(module *models.Module) ([]Glob, error) {
+	if module.Spec == nil {
+		return nil, nil
+	}
+
+	globs := make([]Glob, 0, len(module.Spec.CRDs))
+	for _, pattern := range module.Spec.CRDs {
+		negated, isNegated := strings.CutPrefix(pattern, "!")
+		files, err := sourceOf(module).Glob(negated)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, Glob{Pattern: pattern, Files: files, Negated: isNegated})
+	}
+
+	return globs, nil
+}
+
+// sourceOf returns the source of the module, modules without a source are read from the local filesystem
+func sourceOf(module *models.Module) *source.Source {
+	if module.Source == nil {
+		return source.Disk()
+	}
+	return module.Source
+}
+
+func (p *parser) processFile(ctx context.Context, src *source.Source, path string, cfg *config.Config, spec *models.Spec) (decisions []Decision, err error) {
+	file, err := src.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
 	reader := apimachineryYaml.NewDocumentDecoder(file)
-	for {
+	for document := 0; ; document++ {
 		n, err := reader.Read(p.buffer)
 		if err != nil {
 			if err == io.EOF {
@@ -116,18 +233,39 @@ func (p *parser) processFile(ctx context.Context, path string, spec *models.Spec
 			continue
 		}
 
-		crd, err := p.parseCRD(ctx, spec, bytes.NewReader(data), n)
+		crd, err := p.parseCRD(ctx, bytes.NewReader(data), n)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("document %d: %w", document, err)
 		}
 		if crd != nil {
-			crds = append(crds, crd)
+			verdict, origin := filter(cfg, spec, crd.Spec.Group, crd.Spec.Names.Plural)
+			versions := versionsOf(crd)
+			if crd.APIVersion == legacyAPIVersion {
+				if versions, err = legacyVersionsOf(data, versions); err != nil {
+					return nil, fmt.Errorf("document %d: %w", document, err)
+				}
+			}
+			// the accepted resource can not be used without served versions, CRDs without versions at all are kept,
+			// the origin still points to the entry which allowed the resource
+			if verdict == VerdictAccepted && len(versions) != 0 && !slices.ContainsFunc(versions, func(version Version) bool { return version.Served }) {
+				verdict = VerdictNotServed
+			}
+			decisions = append(decisions, Decision{
+				File:     path,
+				Document: document,
+				Group:    crd.Spec.Group,
+				Resource: crd.Spec.Names.Plural,
+				Scope:    string(crd.Spec.Scope),
+				Verdict:  verdict,
+				Origin:   origin,
+				Versions: versions,
+			})
 		}
 	}
-	return crds, nil
+	return decisions, nil
 }
 
-func (p *parser) parseCRD(_ context.Context, spec *models.Spec, reader io.Reader, bufferSize int) (*apiextensionv1.CustomResourceDefinition, error) {
+func (p *parser) parseCRD(_ context.Context, reader io.Reader, bufferSize int) (*apiextensionv1.CustomResourceDefinition, error) {
 	var crd *apiextensionv1.CustomResourceDefinition
 	if err := apimachineryYaml.NewYAMLOrJSONDecoder(reader, bufferSize).Decode(&crd); err != nil {
 		return nil, err
@@ -142,27 +280,103 @@ func (p *parser) parseCRD(_ context.Context, spec *models.Spec, reader io.Reader
 		return nil, fmt.Errorf("invalid CRD('%s/%s')", crd.APIVersion, crd.Kind)
 	}
 
-	if filter(spec, crd.Spec.Group, crd.Spec.Names.Plural) {
-		return crd, nil
+	return crd, nil
+}
+
+func versionsOf(crd *apiextensionv1.CustomResourceDefinition) []Version {
+	versions := make([]Version, 0, len(crd.Spec.Versions))
+	for _, version := range crd.Spec.Versions {
+		converted := Version{
+			Name:       version.Name,
+			Served:     version.Served,
+			Storage:    version.Storage,
+			Deprecated: version.Deprecated,
+		}
+		if version.DeprecationWarning != nil {
+			converted.DeprecationWarning = *version.DeprecationWarning
+		}
+		if version.Subresources != nil {
+			converted.Subresources = subresourcesOf(version.Subresources)
+		}
+		versions = append(versions, converted)
+	}
+	return versions
+}
+
+func subresourcesOf(subresources *apiextensionv1.CustomResourceSubresources) []string {
+	var names []string
+	if subresources.Status != nil {
+		names = append(names, SubresourceStatus)
+	}
+	if subresources.Scale != nil {
+		names = append(names, SubresourceScale)
 	}
+	return names
+}
+
+// legacyCRD contains fields of apiextensions.k8s.io/v1beta1 CRDs which are moved into spec.versions by v1
+type legacyCRD struct {
+	Spec struct {
+		Version      string                                     `json:"version"`
+		Subresources *apiextensionv1.CustomResourceSubresources `json:"subresources"`
+	} `json:"spec"`
+}
 
-	return nil, nil
+// legacyVersionsOf completes versions of the v1beta1 CRD, the single spec.version is served and stored
+// if spec.versions is empty, and spec.subresources apply to every version
+func legacyVersionsOf(data []byte, versions []Version) ([]Version, error) {
+	var legacy legacyCRD
+	if err := apimachineryYaml.Unmarshal(data, &legacy); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 && legacy.Spec.Version != "" {
+		versions = []Version{{Name: legacy.Spec.Version, Served: true, Storage: true}}
+	}
+	if legacy.Spec.Subresources != nil {
+		for idx := range versions {
+			versions[idx].Subresources = subresourcesOf(legacy.Spec.Subresources)
+		}
+	}
+	return versions, nil
 }
 
-func filter(spec *models.Spec, group, resource string) bool {
+// filter returns the verdict for the resource and the file declaring the spec entry the verdict is made by
+func filter(cfg *config.Config, spec *models.Spec, group, resource string) (Verdict, string) {
 	if slices.Contains(spec.ForbiddenResources, resource) {
-		return false
+		return VerdictForbidden, spec.ForbiddenOrigin(resource)
 	}
 
-	if group == deckhouseGroup {
-		return true
+	if group == cfg.TrustedGroup || slices.Contains(cfg.AllowedGroups, group) {
+		return VerdictAccepted, ""
 	}
 
 	for _, allowed := range spec.AllowedResources {
-		if allowed.Group == group && (slices.Contains(allowed.Resources, resource) || allowed.Resources[0] == allResources) {
-			return true
+		if allowed.Group != group {
+			continue
+		}
+		if slices.Contains(allowed.Resources, resource) {
+			return VerdictAccepted, spec.AllowedOrigin(group, resource)
+		}
+		if slices.Contains(allowed.Resources, models.AllResources) {
+			return VerdictAccepted, spec.AllowedOrigin(group, models.AllResources)
 		}
 	}
 
-	return false
+	return VerdictGroupNotAllowed, ""
+}
+
+// Subresources returns names of the subresources enabled for the served versions
+func Subresources(versions []Version) []string {
+	var subresources []string
+	for _, version := range versions {
+		if !version.Served {
+			continue
+		}
+		for _, subresource := range version.Subresources {
+			if !slices.Contains(subresources, subresource) {
+				subresources = append(subresources, subresource)
+			}
+		}
+	}
+	return subresources
 }
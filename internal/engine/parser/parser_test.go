@@ -0,0 +1,227 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+)
+
+// allowed returns the spec declared in rbac.yaml which allows the resources of the group
+func allowed(group string, resources ...string) *models.Spec {
+	spec := &models.Spec{AllowedResources: []models.Resource{{Group: group, Resources: resources}}}
+	spec.SetOrigins(models.SpecFile)
+	return spec
+}
+
+// forbidden returns the spec declared in rbac.yaml which forbids the resources
+func forbidden(resources ...string) *models.Spec {
+	spec := &models.Spec{ForbiddenResources: resources}
+	spec.SetOrigins(models.SpecFile)
+	return spec
+}
+
+func TestParseVersions(t *testing.T) {
+	tests := []struct {
+		name         string
+		crd          string
+		spec         *models.Spec
+		wantVerdict  Verdict
+		wantOrigin   string
+		wantVersions []Version
+	}{
+		{
+			name: "served version",
+			crd: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+spec:
+  group: deckhouse.io
+  scope: Namespaced
+  names:
+    plural: widgets
+  versions:
+    - name: v1alpha1
+      served: false
+      storage: false
+    - name: v1
+      served: true
+      storage: true
+      subresources:
+        status: {}
+`,
+			wantVerdict: VerdictAccepted,
+			wantVersions: []Version{
+				{Name: "v1alpha1"},
+				{Name: "v1", Served: true, Storage: true, Subresources: []string{SubresourceStatus}},
+			},
+		},
+		{
+			name: "no served versions",
+			crd: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+spec:
+  group: deckhouse.io
+  scope: Namespaced
+  names:
+    plural: widgets
+  versions:
+    - name: v1
+      served: false
+      storage: true
+`,
+			wantVerdict:  VerdictNotServed,
+			wantVersions: []Version{{Name: "v1", Storage: true}},
+		},
+		{
+			name: "no served versions of the allowed resource",
+			crd: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+spec:
+  group: example.io
+  scope: Namespaced
+  names:
+    plural: widgets
+  versions:
+    - name: v1
+      served: false
+      storage: true
+`,
+			spec:         allowed("example.io", "widgets"),
+			wantVerdict:  VerdictNotServed,
+			wantOrigin:   "rbac.yaml",
+			wantVersions: []Version{{Name: "v1", Storage: true}},
+		},
+		{
+			name: "no served versions of the forbidden resource",
+			crd: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+spec:
+  group: deckhouse.io
+  scope: Namespaced
+  names:
+    plural: widgets
+  versions:
+    - name: v1
+      served: false
+      storage: true
+`,
+			spec:         forbidden("widgets"),
+			wantVerdict:  VerdictForbidden,
+			wantOrigin:   "rbac.yaml",
+			wantVersions: []Version{{Name: "v1", Storage: true}},
+		},
+		{
+			name: "no served versions of the not allowed group",
+			crd: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+spec:
+  group: example.io
+  scope: Namespaced
+  names:
+    plural: widgets
+  versions:
+    - name: v1
+      served: false
+      storage: true
+`,
+			wantVerdict:  VerdictGroupNotAllowed,
+			wantVersions: []Version{{Name: "v1", Storage: true}},
+		},
+		{
+			name: "legacy single version",
+			crd: `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+spec:
+  group: deckhouse.io
+  scope: Namespaced
+  names:
+    plural: widgets
+  version: v1beta1
+  subresources:
+    status: {}
+    scale:
+      specReplicasPath: .spec.replicas
+      statusReplicasPath: .status.replicas
+`,
+			wantVerdict:  VerdictAccepted,
+			wantVersions: []Version{{Name: "v1beta1", Served: true, Storage: true, Subresources: []string{SubresourceStatus, SubresourceScale}}},
+		},
+		{
+			name: "legacy versions with common subresources",
+			crd: `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+spec:
+  group: deckhouse.io
+  scope: Namespaced
+  names:
+    plural: widgets
+  version: v1beta1
+  versions:
+    - name: v1beta1
+      served: true
+      storage: true
+    - name: v1alpha1
+      served: true
+      storage: false
+  subresources:
+    status: {}
+`,
+			wantVerdict: VerdictAccepted,
+			wantVersions: []Version{
+				{Name: "v1beta1", Served: true, Storage: true, Subresources: []string{SubresourceStatus}},
+				{Name: "v1alpha1", Served: true, Subresources: []string{SubresourceStatus}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "module")
+			src, err := source.Memory(dir, map[string][]byte{"crds/widget.yaml": []byte(tt.crd)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec := tt.spec
+			if spec == nil {
+				spec = new(models.Spec)
+			}
+			spec.CRDs = []string{filepath.Join(dir, "crds", "*.yaml")}
+			module := &models.Module{Path: dir, Source: src, Definition: &models.Definition{Name: "foo"}, Spec: spec}
+
+			parsed, err := Parse(context.Background(), config.Default(), module)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parsed.Decisions) != 1 {
+				t.Fatalf("Parse() decisions = %+v, want one", parsed.Decisions)
+			}
+			decision := parsed.Decisions[0]
+			if decision.Verdict != tt.wantVerdict {
+				t.Errorf("Parse() verdict = %q, want %q", decision.Verdict, tt.wantVerdict)
+			}
+			if decision.Origin != tt.wantOrigin {
+				t.Errorf("Parse() origin = %q, want %q", decision.Origin, tt.wantOrigin)
+			}
+			if !reflect.DeepEqual(decision.Versions, tt.wantVersions) {
+				t.Errorf("Parse() versions = %+v, want %+v", decision.Versions, tt.wantVersions)
+			}
+		})
+	}
+}
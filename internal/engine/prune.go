@@ -0,0 +1,106 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/output"
+	"github.com/deckhouse/rbacgen/internal/engine/renderer"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+)
+
+// staleFiles returns files of the source in the templates dirs of the modules which were not generated by the current run
+func staleFiles(cfg *config.Config, modules []*models.Module, generated []string, locator output.Locator, src *source.Source) ([]string, error) {
+	owned := sets.New[string]()
+	for _, path := range generated {
+		owned.Insert(filepath.Clean(locator.Locate(path)))
+	}
+
+	var stale []string
+	for _, module := range modules {
+		dir := locator.Locate(renderer.TemplatesDir(cfg, module.Path))
+		err := src.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if !entry.IsDir() && !owned.Has(filepath.Clean(path)) {
+				stale = append(stale, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stale, nil
+}
+
+// prune removes the stale files and the dirs left empty under the templates dirs of the modules
+func prune(cfg *config.Config, modules []*models.Module, stale []string, locator output.Locator) error {
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	for _, module := range modules {
+		dir := locator.Locate(renderer.TemplatesDir(cfg, module.Path))
+		var dirs []string
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() && path != dir {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// remove nested dirs first
+		slices.Reverse(dirs)
+		for _, path := range dirs {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				if err = os.Remove(path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renderer
+
+import (
+	"maps"
+	"slices"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+)
+
+// verbsOf returns view and edit verbs of the resource, the first policy matching the resource overrides the defaults
+func verbsOf(policies []models.ResourcePolicy, group, resource string) ([]string, []string) {
+	view, edit := verbsView, verbsEdit
+	idx := slices.IndexFunc(policies, func(policy models.ResourcePolicy) bool {
+		return policy.Matches(group, resource)
+	})
+	if idx == -1 {
+		return view, edit
+	}
+	if policies[idx].View != nil {
+		view = policies[idx].View
+	}
+	if policies[idx].Edit != nil {
+		edit = policies[idx].Edit
+	}
+	return view, edit
+}
+
+// resourceRules returns rules of the resources by groups, resources of the group with the same verbs share a rule
+func resourceRules(policies []models.ResourcePolicy, resources map[string][]string, edit bool) []rbacv1.PolicyRule {
+	var rules []rbacv1.PolicyRule
+	for _, group := range slices.Sorted(maps.Keys(resources)) {
+		var groupRules []rbacv1.PolicyRule
+		for _, resource := range resources[group] {
+			verbs, editVerbs := verbsOf(policies, group, resource)
+			if edit {
+				verbs = editVerbs
+			}
+			if len(verbs) == 0 {
+				continue
+			}
+			idx := slices.IndexFunc(groupRules, func(rule rbacv1.PolicyRule) bool {
+				return slices.Equal(rule.Verbs, verbs)
+			})
+			if idx == -1 {
+				groupRules = append(groupRules, rbacv1.PolicyRule{APIGroups: []string{group}, Resources: []string{resource}, Verbs: verbs})
+				continue
+			}
+			groupRules[idx].Resources = append(groupRules[idx].Resources, resource)
+		}
+		rules = append(rules, groupRules...)
+	}
+	return rules
+}
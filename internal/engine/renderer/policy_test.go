@@ -0,0 +1,96 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renderer
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/parser"
+)
+
+var testPolicies = []models.ResourcePolicy{
+	{Group: "example.io", Resources: []string{"tokens"}, Edit: []string{}},
+	{Group: "example.io", Resources: []string{"requests"}, Edit: []string{"create", "update", "patch", "delete", "approve"}},
+	{Group: "example.io", Resources: []string{"requests"}, Edit: []string{"get"}},
+	{Group: "audit.io", Resources: []string{models.AllResources}, View: []string{"get"}, Edit: []string{}},
+}
+
+func TestVerbsOf(t *testing.T) {
+	tests := []struct {
+		group, resource string
+		wantView        []string
+		wantEdit        []string
+	}{
+		{group: "example.io", resource: "widgets", wantView: verbsView, wantEdit: verbsEdit},
+		{group: "example.io", resource: "tokens", wantView: verbsView, wantEdit: []string{}},
+		{group: "example.io", resource: "requests", wantView: verbsView, wantEdit: []string{"create", "update", "patch", "delete", "approve"}},
+		{group: "other.io", resource: "tokens", wantView: verbsView, wantEdit: verbsEdit},
+		{group: "audit.io", resource: "events", wantView: []string{"get"}, wantEdit: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resource+"."+tt.group, func(t *testing.T) {
+			view, edit := verbsOf(testPolicies, tt.group, tt.resource)
+			if !reflect.DeepEqual(view, tt.wantView) || !reflect.DeepEqual(edit, tt.wantEdit) {
+				t.Errorf("verbsOf() = %v, %v, want %v, %v", view, edit, tt.wantView, tt.wantEdit)
+			}
+		})
+	}
+}
+
+func TestResourceRules(t *testing.T) {
+	resources := map[string][]string{
+		"example.io": {"widgets", "tokens", "requests", "gadgets"},
+		"audit.io":   {"events"},
+	}
+
+	view := resourceRules(testPolicies, resources, false)
+	wantView := []rbacv1.PolicyRule{
+		{APIGroups: []string{"audit.io"}, Resources: []string{"events"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"example.io"}, Resources: []string{"widgets", "tokens", "requests", "gadgets"}, Verbs: verbsView},
+	}
+	if !reflect.DeepEqual(view, wantView) {
+		t.Errorf("resourceRules() view = %+v, want %+v", view, wantView)
+	}
+
+	edit := resourceRules(testPolicies, resources, true)
+	wantEdit := []rbacv1.PolicyRule{
+		{APIGroups: []string{"example.io"}, Resources: []string{"widgets", "gadgets"}, Verbs: verbsEdit},
+		{APIGroups: []string{"example.io"}, Resources: []string{"requests"}, Verbs: []string{"create", "update", "patch", "delete", "approve"}},
+	}
+	if !reflect.DeepEqual(edit, wantEdit) {
+		t.Errorf("resourceRules() edit = %+v, want %+v", edit, wantEdit)
+	}
+}
+
+func TestSubresourceRulesPolicies(t *testing.T) {
+	resources := map[string][]string{"example.io": {"widgets", "tokens"}}
+	versions := map[string][]parser.Version{
+		"widgets.example.io": {{Name: "v1", Served: true, Storage: true, Subresources: []string{parser.SubresourceStatus, parser.SubresourceScale}}},
+		"tokens.example.io":  {{Name: "v1", Served: true, Storage: true, Subresources: []string{parser.SubresourceScale}}},
+	}
+
+	edit := subresourceRules(defaultSubresources, testPolicies, resources, versions, true)
+	// tokens are read-only, their scale is not editable
+	want := []rbacv1.PolicyRule{
+		{APIGroups: []string{"example.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"update", "patch"}},
+	}
+	if !reflect.DeepEqual(edit, want) {
+		t.Errorf("subresourceRules() edit = %+v, want %+v", edit, want)
+	}
+}
@@ -15,10 +15,9 @@
 package renderer
 
 import (
-	"cmp"
 	"context"
 	"fmt"
-	"os"
+	"log/slog"
 	"path/filepath"
 	"sigs.k8s.io/yaml"
 	"slices"
@@ -27,8 +26,10 @@ import (
 	rbacv1 "k8s.io/api/rbac/v1"
 	apimachineryv1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/deckhouse/rbacgen/internal/engine/config"
 	"github.com/deckhouse/rbacgen/internal/engine/doc"
 	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/output"
 	"github.com/deckhouse/rbacgen/internal/engine/parser"
 )
 
@@ -43,8 +44,6 @@ const (
 
 	roleManager = "manager"
 	roleViewer  = "viewer"
-
-	templatesPath = "templates/rbacv2"
 )
 
 var (
@@ -52,90 +51,95 @@ var (
 	verbsEdit = []string{"create", "update", "patch", "delete", "deletecollection"}
 
 	subsystemTemplate = "rbac.deckhouse.io/aggregate-to-%s-as"
+
+	descriptionAnnotation = "rbac.deckhouse.io/description"
 )
 
-func Render(ctx context.Context, modules []*models.Module) (*doc.Docs, error) {
+// Render writes roles of the modules and returns the docs with the rendered roles of every module
+func Render(ctx context.Context, cfg *config.Config, modules []*models.Module, out output.Writer) (*doc.Docs, []*Roles, error) {
 	docs := doc.New()
+	rendered := make([]*Roles, 0, len(modules))
 	for _, module := range modules {
-		if err := render(ctx, module, docs); err != nil {
-			return nil, err
+		slog.InfoContext(ctx, "render module", "module", module.Definition.Name, "path", module.Path)
+		roles, err := render(ctx, cfg, module, docs, out)
+		if err != nil {
+			return nil, nil, fmt.Errorf("module '%s' (%s): %w", module.Definition.Name, module.Path, err)
 		}
+		rendered = append(rendered, roles)
 	}
-	return docs, nil
+	return docs, rendered, nil
+}
+
+// Roles contains the parsed CRDs of the module and roles built from them
+type Roles struct {
+	Module *models.Module
+	Parsed *parser.ParsedCRDs
+	Manage []*rbacv1.ClusterRole
+	Use    []*rbacv1.ClusterRole
 }
 
-func render(ctx context.Context, module *models.Module, docs *doc.Docs) error {
-	parsed, err := parser.Parse(ctx, module)
+// Build parses CRDs of the module and builds its roles without writing them
+func Build(ctx context.Context, cfg *config.Config, module *models.Module) (*Roles, error) {
+	parsed, err := parser.Parse(ctx, cfg, module)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	manage, use := buildRoles(module, parsed.Cluster, parsed.Namespaced)
+	manage, use := buildRoles(module, parsed.Cluster, parsed.Namespaced, parsed.Versions)
+
+	return &Roles{Module: module, Parsed: parsed, Manage: manage, Use: use}, nil
+}
+
+func render(ctx context.Context, cfg *config.Config, module *models.Module, docs *doc.Docs, out output.Writer) (*Roles, error) {
+	roles, err := Build(ctx, cfg, module)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, role := range manage {
-		if err = writeRole(module.Path, role); err != nil {
-			return err
+	for _, role := range roles.Manage {
+		if err = writeRole(out, TemplatesDir(cfg, module.Path), role); err != nil {
+			return nil, err
 		}
 	}
 
-	for _, role := range use {
-		if err = writeRole(module.Path, role); err != nil {
-			return err
+	for _, role := range roles.Use {
+		if err = writeRole(out, TemplatesDir(cfg, module.Path), role); err != nil {
+			return nil, err
 		}
 	}
 
-	docs.AddModule(module, manage, use)
+	docs.AddModule(module, roles.Manage, roles.Use, roles.Parsed.Versions)
 	docs.AddSubsystem(module)
 
-	return nil
+	return roles, nil
 }
 
-func buildRoles(module *models.Module, manageResources, useResources map[string][]string) ([]*rbacv1.ClusterRole, []*rbacv1.ClusterRole) {
-	var useViewRules, useEditRules, manageViewRules, manageEditRules []rbacv1.PolicyRule
-
-	// rules for manage roles
-	for group, resources := range manageResources {
-		manageViewRules = append(manageViewRules, rbacv1.PolicyRule{
-			APIGroups: []string{group},
-			Resources: resources,
-			Verbs:     verbsView,
-		})
-		manageEditRules = append(manageEditRules, rbacv1.PolicyRule{
-			APIGroups: []string{group},
-			Resources: resources,
-			Verbs:     verbsEdit,
-		})
+func buildRoles(module *models.Module, manageResources, useResources map[string][]string, versions map[string][]parser.Version) ([]*rbacv1.ClusterRole, []*rbacv1.ClusterRole) {
+	// resources of manage roles are sorted
+	for _, resources := range manageResources {
+		slices.Sort(resources)
 	}
 
-	slices.SortFunc(manageViewRules, func(r1, r2 rbacv1.PolicyRule) int {
-		return cmp.Compare(r1.APIGroups[0], r2.APIGroups[0])
-	})
-
-	for _, group := range manageViewRules {
-		slices.Sort(group.Resources)
+	var policies []models.ResourcePolicy
+	if module.Spec != nil {
+		policies = module.Spec.ResourcePolicies
 	}
 
-	slices.SortFunc(useEditRules, func(r1, r2 rbacv1.PolicyRule) int {
-		return cmp.Compare(r1.APIGroups[0], r2.APIGroups[0])
-	})
-
-	for _, group := range useEditRules {
-		slices.Sort(group.Resources)
-	}
+	// rules for manage roles
+	manageViewRules := resourceRules(policies, manageResources, false)
+	manageEditRules := resourceRules(policies, manageResources, true)
 
 	// rules for use roles
-	for group, resources := range useResources {
-		useViewRules = append(useViewRules, rbacv1.PolicyRule{
-			APIGroups: []string{group},
-			Resources: resources,
-			Verbs:     verbsView,
-		})
-		useEditRules = append(useEditRules, rbacv1.PolicyRule{
-			APIGroups: []string{group},
-			Resources: resources,
-			Verbs:     verbsEdit,
-		})
-	}
+	useViewRules := resourceRules(policies, useResources, false)
+	useEditRules := resourceRules(policies, useResources, true)
+
+	subresources := subresourcesOf(module)
+	// resources of custom subresources may have no CRDs in the module
+	manageResources, useResources = listedResources(subresources, manageResources, useResources)
+	manageViewRules = append(manageViewRules, subresourceRules(subresources, policies, manageResources, versions, false)...)
+	manageEditRules = append(manageEditRules, subresourceRules(subresources, policies, manageResources, versions, true)...)
+	useViewRules = append(useViewRules, subresourceRules(subresources, policies, useResources, versions, false)...)
+	useEditRules = append(useEditRules, subresourceRules(subresources, policies, useResources, versions, true)...)
 
 	//deckhouse can manage all module configs
 	if module.Definition.Name != moduleDeckhouse {
@@ -159,7 +163,8 @@ func buildRoles(module *models.Module, manageResources, useResources map[string]
 	}
 
 	var useRoles []*rbacv1.ClusterRole
-	if len(useEditRules) > 0 && len(useViewRules) > 0 {
+	// read-only resources have no edit rules, the manager role is generated to keep the aggregation
+	if len(useResources) > 0 {
 		useRoles = append(useRoles, buildRole(module, roleViewer, kindUse, verbView, useViewRules))
 		useRoles = append(useRoles, buildRole(module, roleManager, kindUse, verbEdit, useEditRules))
 	}
@@ -211,10 +216,18 @@ func buildRole(module *models.Module, rbacRole, rbacKind, rbacVerb string, rules
 			role.ObjectMeta.Labels["rbac.deckhouse.io/namespace"] = module.Definition.Namespace
 		}
 	}
+	if description := module.Definition.Description(); description != "" {
+		role.ObjectMeta.Annotations = map[string]string{descriptionAnnotation: description}
+	}
 	return role
 }
 
-func writeRole(path string, role *rbacv1.ClusterRole) error {
+// TemplatesDir returns the dir with the generated roles of the module
+func TemplatesDir(cfg *config.Config, modulePath string) string {
+	return filepath.Join(modulePath, cfg.TemplatesPath)
+}
+
+func writeRole(out output.Writer, dir string, role *rbacv1.ClusterRole) error {
 	kind := kindUse
 	if strings.Contains(role.Name, ":"+kindManage+":") {
 		kind = kindManage
@@ -225,14 +238,14 @@ func writeRole(path string, role *rbacv1.ClusterRole) error {
 		name = verbEdit
 	}
 
-	if err := os.MkdirAll(filepath.Join(path, templatesPath, kind), 0755); err != nil {
-		return err
-	}
-
 	marshaled, err := yaml.Marshal(role)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(filepath.Join(path, templatesPath, kind, fmt.Sprintf("%s.yaml", name)), marshaled, 0644)
+	file := filepath.Join(dir, kind, fmt.Sprintf("%s.yaml", name))
+	if err = out.WriteFile(file, marshaled); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", file, err)
+	}
+	return nil
 }
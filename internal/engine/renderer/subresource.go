@@ -0,0 +1,141 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renderer
+
+import (
+	"maps"
+	"slices"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/parser"
+)
+
+const scopeCluster = "Cluster"
+
+// defaultSubresources are verbs of the subresources declared by CRDs of any group, viewers read the status and the scale,
+// managers scale resources, the status is written by controllers
+var defaultSubresources = []models.Subresource{
+	{Name: parser.SubresourceStatus, Group: models.AnyGroup, View: []string{"get"}, Edit: []string{}},
+	{Name: parser.SubresourceScale, Group: models.AnyGroup, View: []string{"get"}, Edit: []string{"update", "patch"}},
+}
+
+// subresourcesOf returns the default subresources overridden by the spec and the custom subresources of the spec,
+// omitted verbs of the group entries are taken from the entry of any group with the same name
+func subresourcesOf(module *models.Module) []models.Subresource {
+	var subresources []models.Subresource
+	if module.Spec != nil {
+		subresources = module.Spec.Subresources
+	}
+	merged := models.MergeSubresources(subresources, defaultSubresources)
+	for idx, subresource := range merged {
+		if subresource.Group == models.AnyGroup {
+			continue
+		}
+		anyIdx := slices.IndexFunc(merged, func(other models.Subresource) bool {
+			return other.Name == subresource.Name && other.Group == models.AnyGroup
+		})
+		if anyIdx == -1 {
+			continue
+		}
+		if subresource.View == nil {
+			merged[idx].View = merged[anyIdx].View
+		}
+		if subresource.Edit == nil {
+			merged[idx].Edit = merged[anyIdx].Edit
+		}
+	}
+	return merged
+}
+
+// groupSubresources returns the subresources applied to resources of the group, the entry of the group
+// replaces the entry of any group with the same name
+func groupSubresources(subresources []models.Subresource, group string) []models.Subresource {
+	var resolved []models.Subresource
+	for _, subresource := range subresources {
+		switch subresource.Group {
+		case group:
+			resolved = append(resolved, subresource)
+		case models.AnyGroup:
+			if !slices.ContainsFunc(subresources, func(other models.Subresource) bool {
+				return other.Name == subresource.Name && other.Group == group
+			}) {
+				resolved = append(resolved, subresource)
+			}
+		}
+	}
+	return resolved
+}
+
+// subresourceRules returns rules of the subresources of the resources by groups,
+// a resource has a subresource if its served CRD versions declare it or the spec lists it for its group,
+// resources without view or edit verbs by the policies get no view or edit rules of their subresources
+func subresourceRules(subresources []models.Subresource, policies []models.ResourcePolicy, resources map[string][]string, versions map[string][]parser.Version, edit bool) []rbacv1.PolicyRule {
+	var rules []rbacv1.PolicyRule
+	for _, group := range slices.Sorted(maps.Keys(resources)) {
+		for _, subresource := range groupSubresources(subresources, group) {
+			verbs := subresource.View
+			if edit {
+				verbs = subresource.Edit
+			}
+			if len(verbs) == 0 {
+				continue
+			}
+
+			var names []string
+			for _, resource := range slices.Sorted(slices.Values(resources[group])) {
+				view, editVerbs := verbsOf(policies, group, resource)
+				if (edit && len(editVerbs) == 0) || (!edit && len(view) == 0) {
+					continue
+				}
+				declared := parser.Subresources(versions[resource+"."+group])
+				listed := subresource.Group == group && slices.Contains(subresource.Resources, resource)
+				if slices.Contains(declared, subresource.Name) || listed {
+					names = append(names, resource+"/"+subresource.Name)
+				}
+			}
+			if len(names) != 0 {
+				rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{group}, Resources: names, Verbs: verbs})
+			}
+		}
+	}
+	return rules
+}
+
+// listedResources returns copies of the manage and use resources by groups with the resources of the custom subresources
+// which have no CRDs in the module, they are added by the scope of the subresource
+func listedResources(subresources []models.Subresource, manageResources, useResources map[string][]string) (map[string][]string, map[string][]string) {
+	manage, use := make(map[string][]string), make(map[string][]string)
+	maps.Copy(manage, manageResources)
+	maps.Copy(use, useResources)
+	for _, subresource := range subresources {
+		// resources of any group can not be listed
+		if subresource.Group == models.AnyGroup {
+			continue
+		}
+		for _, resource := range subresource.Resources {
+			if slices.Contains(manage[subresource.Group], resource) || slices.Contains(use[subresource.Group], resource) {
+				continue
+			}
+			if subresource.Scope == scopeCluster {
+				manage[subresource.Group] = append(slices.Clone(manage[subresource.Group]), resource)
+				continue
+			}
+			use[subresource.Group] = append(slices.Clone(use[subresource.Group]), resource)
+		}
+	}
+	return manage, use
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package renderer
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/parser"
+)
+
+func TestSubresourceRulesOverrides(t *testing.T) {
+	resources := map[string][]string{"example.io": {"widgets"}, "other.io": {"widgets"}}
+	declared := []parser.Version{{Name: "v1", Served: true, Storage: true, Subresources: []string{parser.SubresourceStatus, parser.SubresourceScale}}}
+	versions := map[string][]parser.Version{"widgets.example.io": declared, "widgets.other.io": declared}
+
+	tests := []struct {
+		name         string
+		subresources []models.Subresource
+		wantView     []rbacv1.PolicyRule
+		wantEdit     []rbacv1.PolicyRule
+	}{
+		{
+			name: "defaults",
+			wantView: []rbacv1.PolicyRule{
+				{APIGroups: []string{"example.io"}, Resources: []string{"widgets/status"}, Verbs: []string{"get"}},
+				{APIGroups: []string{"example.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"get"}},
+				{APIGroups: []string{"other.io"}, Resources: []string{"widgets/status"}, Verbs: []string{"get"}},
+				{APIGroups: []string{"other.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"get"}},
+			},
+			wantEdit: []rbacv1.PolicyRule{
+				{APIGroups: []string{"example.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"update", "patch"}},
+				{APIGroups: []string{"other.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"update", "patch"}},
+			},
+		},
+		{
+			name: "group override",
+			subresources: []models.Subresource{
+				{Name: parser.SubresourceStatus, Group: "example.io", View: []string{}},
+				{Name: parser.SubresourceScale, Group: "other.io", Edit: []string{"patch"}},
+			},
+			wantView: []rbacv1.PolicyRule{
+				{APIGroups: []string{"example.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"get"}},
+				{APIGroups: []string{"other.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"get"}},
+				{APIGroups: []string{"other.io"}, Resources: []string{"widgets/status"}, Verbs: []string{"get"}},
+			},
+			wantEdit: []rbacv1.PolicyRule{
+				{APIGroups: []string{"example.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"update", "patch"}},
+				{APIGroups: []string{"other.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"patch"}},
+			},
+		},
+		{
+			name: "any group override",
+			subresources: []models.Subresource{
+				{Name: parser.SubresourceStatus, Group: models.AnyGroup, Edit: []string{"update"}},
+				{Name: parser.SubresourceStatus, Group: "other.io", View: []string{}},
+			},
+			wantView: []rbacv1.PolicyRule{
+				{APIGroups: []string{"example.io"}, Resources: []string{"widgets/status"}, Verbs: []string{"get"}},
+				{APIGroups: []string{"example.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"get"}},
+				{APIGroups: []string{"other.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"get"}},
+			},
+			wantEdit: []rbacv1.PolicyRule{
+				{APIGroups: []string{"example.io"}, Resources: []string{"widgets/status"}, Verbs: []string{"update"}},
+				{APIGroups: []string{"example.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"update", "patch"}},
+				{APIGroups: []string{"other.io"}, Resources: []string{"widgets/status"}, Verbs: []string{"update"}},
+				{APIGroups: []string{"other.io"}, Resources: []string{"widgets/scale"}, Verbs: []string{"update", "patch"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			module := &models.Module{Definition: &models.Definition{Name: "foo"}, Spec: &models.Spec{Subresources: tt.subresources}}
+			subresources := subresourcesOf(module)
+
+			if view := subresourceRules(subresources, nil, resources, versions, false); !reflect.DeepEqual(view, tt.wantView) {
+				t.Errorf("subresourceRules() view = %+v, want %+v", view, tt.wantView)
+			}
+			if edit := subresourceRules(subresources, nil, resources, versions, true); !reflect.DeepEqual(edit, tt.wantEdit) {
+				t.Errorf("subresourceRules() edit = %+v, want %+v", edit, tt.wantEdit)
+			}
+		})
+	}
+}
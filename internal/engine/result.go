@@ -0,0 +1,115 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/deckhouse/rbacgen/internal/engine/output"
+	"github.com/deckhouse/rbacgen/internal/engine/parser"
+	"github.com/deckhouse/rbacgen/internal/engine/renderer"
+)
+
+const (
+	FileWritten   = "written"
+	FileUnchanged = "unchanged"
+)
+
+// Result is the machine-readable report of the generation run
+type Result struct {
+	// DryRun is true if nothing was written, files statuses show what would be done
+	DryRun  bool           `json:"dryRun"`
+	Modules []ModuleResult `json:"modules"`
+	Files   []FileResult   `json:"files"`
+	// Pruned contains removed stale files, or the files to remove in the dry run
+	Pruned []string `json:"pruned"`
+	// Ignored contains module.yaml files in paths ignored by .gitignore files or the config globs
+	Ignored  []string `json:"ignored"`
+	Warnings []string `json:"warnings"`
+}
+
+type ModuleResult struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"`
+	Edition  string       `json:"edition,omitempty"`
+	CRDFiles []string     `json:"crdFiles"`
+	Roles    []RoleResult `json:"roles"`
+}
+
+type RoleResult struct {
+	Name  string `json:"name"`
+	Rules int    `json:"rules"`
+}
+
+type FileResult struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+func newResult(rendered []*renderer.Roles, trackers ...*output.Tracker) *Result {
+	result := &Result{Modules: []ModuleResult{}, Files: []FileResult{}, Pruned: []string{}, Ignored: []string{}, Warnings: []string{}}
+	for _, roles := range rendered {
+		module := ModuleResult{Name: roles.Module.Definition.Name, Path: roles.Module.Path, Edition: roles.Module.Edition, CRDFiles: []string{}, Roles: []RoleResult{}}
+		skipped := sets.New[string]()
+		for _, decision := range roles.Parsed.Decisions {
+			if decision.Verdict == parser.VerdictDocFile {
+				skipped.Insert(decision.File)
+			}
+			if decision.Verdict == parser.VerdictNotServed {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("module '%s': CRD '%s.%s' in '%s' has no served versions in spec.versions, it is not added to roles",
+					module.Name, decision.Resource, decision.Group, decision.File))
+			}
+		}
+		for _, glob := range roles.Parsed.Globs {
+			if len(glob.Files) == 0 {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("module '%s': CRDs glob '%s' matched nothing", module.Name, glob.Pattern))
+			}
+		}
+		for _, file := range parser.Files(roles.Parsed.Globs) {
+			if !skipped.Has(file) {
+				module.CRDFiles = append(module.CRDFiles, file)
+			}
+		}
+		for _, role := range slices.Concat(roles.Manage, roles.Use) {
+			module.Roles = append(module.Roles, RoleResult{Name: role.Name, Rules: len(role.Rules)})
+		}
+		result.Modules = append(result.Modules, module)
+	}
+
+	for _, tracker := range trackers {
+		for _, path := range tracker.Written {
+			result.Files = append(result.Files, FileResult{Path: path, Status: FileWritten})
+		}
+		for _, path := range tracker.Unchanged {
+			result.Files = append(result.Files, FileResult{Path: path, Status: FileUnchanged})
+		}
+	}
+
+	return result
+}
+
+// WriteReport writes the result as json to the path
+func (r *Result) WriteReport(path string) error {
+	marshaled, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(marshaled, '\n'), 0644)
+}
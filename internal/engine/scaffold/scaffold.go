@@ -0,0 +1,204 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaffold
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/parser"
+	"github.com/deckhouse/rbacgen/internal/engine/schema"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+	"github.com/deckhouse/rbacgen/internal/engine/walker"
+)
+
+// modules dirs are prefixed by the weight, like 040-node-manager
+var weightPrefix = regexp.MustCompile(`^\d+-`)
+
+// Scaffold is the proposed module.yaml and rbac.yaml of the module
+type Scaffold struct {
+	Dir        string
+	Definition *models.Definition
+	Spec       *models.Spec
+	// Groups contains resources found in the module CRDs by group
+	Groups map[string][]string
+	// definition is the existing module.yaml, it is updated in place to keep other fields and comments
+	definition *yaml.Node
+}
+
+// Inspect reads the existing module.yaml and the module CRDs from the source, resources of groups allowed by the config are not proposed
+func Inspect(ctx context.Context, src *source.Source, cfg *config.Config, dir string) (*Scaffold, error) {
+	scaffold := &Scaffold{
+		Dir:        dir,
+		Definition: &models.Definition{Name: weightPrefix.ReplaceAllString(filepath.Base(filepath.Clean(dir)), "")},
+		Spec:       new(models.Spec),
+		Groups:     make(map[string][]string),
+	}
+
+	raw, err := src.ReadFile(filepath.Join(dir, models.DefinitionFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		scaffold.definition = new(yaml.Node)
+		if err = yaml.Unmarshal(raw, scaffold.definition); err != nil {
+			return nil, fmt.Errorf("failed to parse '%s': %w", models.DefinitionFile, err)
+		}
+		if err = schema.DecodeLenient(filepath.Join(dir, models.DefinitionFile), raw, scaffold.Definition); err != nil {
+			return nil, err
+		}
+	}
+
+	globs, err := walker.DiscoverCRDs(src, cfg, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// the spec allows no groups, but decisions contain every resource, even the rejected ones
+	parsed, err := parser.Parse(ctx, cfg, &models.Module{Path: dir, Source: src, Definition: scaffold.Definition, Spec: &models.Spec{CRDs: globs}})
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]sets.Set[string])
+	for _, decision := range parsed.Decisions {
+		if decision.Resource == "" {
+			continue
+		}
+		if _, ok := found[decision.Group]; !ok {
+			found[decision.Group] = sets.New[string]()
+		}
+		found[decision.Group].Insert(decision.Resource)
+	}
+
+	for group, resources := range found {
+		scaffold.Groups[group] = sets.List(resources)
+		if group != cfg.TrustedGroup && !slices.Contains(cfg.AllowedGroups, group) {
+			scaffold.Spec.AllowedResources = append(scaffold.Spec.AllowedResources, models.Resource{Group: group, Resources: scaffold.Groups[group]})
+		}
+	}
+	sort.Slice(scaffold.Spec.AllowedResources, func(i, j int) bool {
+		return scaffold.Spec.AllowedResources[i].Group < scaffold.Spec.AllowedResources[j].Group
+	})
+
+	return scaffold, nil
+}
+
+// Write writes module.yaml and rbac.yaml, rbac.yaml is written only if there are resources to allow,
+// existing files are kept unless they miss the proposed values or force is set,
+// it returns paths of the written and the kept files
+func (s *Scaffold) Write(force bool) (written, kept []string, err error) {
+	if len(s.Definition.Subsystems) == 0 {
+		return nil, nil, errors.New("at least one subsystem is required")
+	}
+
+	definitionPath := filepath.Join(s.Dir, models.DefinitionFile)
+	if s.definition == nil || force || !s.complete() {
+		definition, err := s.marshalDefinition()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = os.WriteFile(definitionPath, definition, 0644); err != nil {
+			return nil, nil, err
+		}
+		written = append(written, definitionPath)
+	} else {
+		kept = append(kept, definitionPath)
+	}
+
+	if len(s.Spec.AllowedResources) == 0 {
+		return written, kept, nil
+	}
+
+	specPath := filepath.Join(s.Dir, models.SpecFile)
+	if _, err = os.Stat(specPath); err == nil && !force {
+		return written, append(kept, specPath), nil
+	}
+	spec, err := marshal(s.Spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = os.WriteFile(specPath, spec, 0644); err != nil {
+		return nil, nil, err
+	}
+
+	return append(written, specPath), kept, nil
+}
+
+// complete returns true if the existing module.yaml already has everything the generator needs
+func (s *Scaffold) complete() bool {
+	existing := new(models.Definition)
+	if err := s.definition.Decode(existing); err != nil {
+		return false
+	}
+	return existing.Name == s.Definition.Name &&
+		existing.Namespace == s.Definition.Namespace &&
+		slices.Equal(existing.Subsystems, s.Definition.Subsystems)
+}
+
+func (s *Scaffold) marshalDefinition() ([]byte, error) {
+	if s.definition == nil || len(s.definition.Content) == 0 || s.definition.Content[0].Kind != yaml.MappingNode {
+		return marshal(s.Definition)
+	}
+
+	mapping := s.definition.Content[0]
+	setField(mapping, "name", &yaml.Node{Kind: yaml.ScalarNode, Value: s.Definition.Name})
+	if s.Definition.Namespace != "" {
+		setField(mapping, "namespace", &yaml.Node{Kind: yaml.ScalarNode, Value: s.Definition.Namespace})
+	}
+	subsystems := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, subsystem := range s.Definition.Subsystems {
+		subsystems.Content = append(subsystems.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: subsystem})
+	}
+	setField(mapping, "subsystems", subsystems)
+
+	return marshal(s.definition)
+}
+
+func marshal(in any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(in); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func setField(mapping *yaml.Node, key string, value *yaml.Node) {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			mapping.Content[idx+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
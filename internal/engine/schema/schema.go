@@ -0,0 +1,261 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const draft = "https://json-schema.org/draft/2020-12/schema"
+
+var unmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+
+// schemer is implemented by types decoded by their own yaml.Unmarshaler
+type schemer interface {
+	JSONSchema() map[string]any
+}
+
+// Decode strictly decodes the yaml into the out, unknown fields, missing required fields and values of wrong kinds
+// are reported with the file, the line and the column, fields are matched by the yaml tags of the out type,
+// fields without omitempty are required
+func Decode(path string, raw []byte, out any) error {
+	return decode(path, raw, out, false)
+}
+
+// DecodeLenient decodes the yaml like Decode, but unknown fields are logged as warnings,
+// it is used for files which have fields owned by other tools
+func DecodeLenient(path string, raw []byte, out any) error {
+	return decode(path, raw, out, true)
+}
+
+func decode(path string, raw []byte, out any, lenient bool) error {
+	node := new(yaml.Node)
+	if err := yaml.Unmarshal(raw, node); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	// the empty file keeps defaults
+	if node.Kind == 0 || len(node.Content) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, err := range check(path, node.Content[0], reflect.TypeOf(out)) {
+		var unknown *UnknownFieldError
+		if lenient && errors.As(err, &unknown) {
+			slog.Warn("skip unknown field", "file", unknown.Path, "line", unknown.Line, "column", unknown.Column, "field", unknown.Field)
+			continue
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+
+	if err := node.Content[0].Decode(out); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// UnknownFieldError is returned for a field which is not in the decoded type
+type UnknownFieldError struct {
+	Path   string
+	Line   int
+	Column int
+	Field  string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: unknown field '%s'", e.Path, e.Line, e.Column, e.Field)
+}
+
+// check compares the node with the type recursively
+func check(path string, node *yaml.Node, typ reflect.Type) []error {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	// null is decoded as the zero value, types with own decoding are checked by the decoder
+	if (node.Kind == yaml.ScalarNode && node.Tag == "!!null") || reflect.PointerTo(typ).Implements(unmarshalerType) {
+		return nil
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return []error{mismatch(path, node, "mapping")}
+		}
+		fields := fieldsOf(typ)
+		var errs []error
+		seen := make(map[string]bool, len(fields))
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key, value := node.Content[idx], node.Content[idx+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, &UnknownFieldError{Path: path, Line: key.Line, Column: key.Column, Field: key.Value})
+				continue
+			}
+			seen[key.Value] = true
+			errs = append(errs, check(path, value, field.Type)...)
+		}
+		for _, name := range slices.Sorted(maps.Keys(fields)) {
+			if fields[name].Required && !seen[name] {
+				errs = append(errs, fmt.Errorf("%s:%d:%d: missing required field '%s'", path, node.Line, node.Column, name))
+			}
+		}
+		return errs
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return []error{mismatch(path, node, "mapping")}
+		}
+		var errs []error
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			errs = append(errs, check(path, node.Content[idx+1], typ.Elem())...)
+		}
+		return errs
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return []error{mismatch(path, node, "sequence")}
+		}
+		var errs []error
+		for _, item := range node.Content {
+			errs = append(errs, check(path, item, typ.Elem())...)
+		}
+		return errs
+	case reflect.Bool:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!bool" {
+			return []error{mismatch(path, node, "boolean")}
+		}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != "!!int" {
+			return []error{mismatch(path, node, "integer")}
+		}
+	case reflect.String:
+		if node.Kind != yaml.ScalarNode {
+			return []error{mismatch(path, node, "string")}
+		}
+	}
+	return nil
+}
+
+func mismatch(path string, node *yaml.Node, expected string) error {
+	got := "scalar"
+	switch node.Kind {
+	case yaml.MappingNode:
+		got = "mapping"
+	case yaml.SequenceNode:
+		got = "sequence"
+	case yaml.ScalarNode:
+		got = fmt.Sprintf("'%s'", node.Value)
+	}
+	return fmt.Errorf("%s:%d:%d: expected %s, got %s", path, node.Line, node.Column, expected, got)
+}
+
+// field is a struct field decoded from yaml
+type field struct {
+	Type     reflect.Type
+	Required bool
+}
+
+// fieldsOf returns fields of the struct by their yaml names, fields without omitempty are required
+func fieldsOf(typ reflect.Type) map[string]field {
+	fields := make(map[string]field)
+	for idx := 0; idx < typ.NumField(); idx++ {
+		structField := typ.Field(idx)
+		if !structField.IsExported() {
+			continue
+		}
+		tag := structField.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = strings.ToLower(structField.Name)
+		}
+		fields[name] = field{Type: structField.Type, Required: !strings.Contains(options, "omitempty")}
+	}
+	return fields
+}
+
+// JSONSchema returns the JSON Schema of the type built from its yaml tags, it matches files decoded by Decode
+func JSONSchema(title string, value any) ([]byte, error) {
+	return jsonSchema(title, value, false)
+}
+
+// JSONSchemaLenient returns the JSON Schema like JSONSchema, but objects allow unknown properties
+// like DecodeLenient does
+func JSONSchemaLenient(title string, value any) ([]byte, error) {
+	return jsonSchema(title, value, true)
+}
+
+func jsonSchema(title string, value any, lenient bool) ([]byte, error) {
+	schema := build(reflect.TypeOf(value), lenient)
+	schema["$schema"] = draft
+	schema["title"] = title
+	marshaled, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(marshaled, '\n'), nil
+}
+
+func build(typ reflect.Type, lenient bool) map[string]any {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if value, ok := reflect.Zero(typ).Interface().(schemer); ok {
+		return value.JSONSchema()
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]any)
+		var required []string
+		for name, field := range fieldsOf(typ) {
+			properties[name] = build(field.Type, lenient)
+			if field.Required {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties, "additionalProperties": lenient}
+		if len(required) != 0 {
+			slices.Sort(required)
+			schema["required"] = required
+		}
+		return schema
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": build(typ.Elem(), lenient)}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": build(typ.Elem(), lenient)}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return map[string]any{"type": "integer"}
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
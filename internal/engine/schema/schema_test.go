@@ -0,0 +1,162 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type testResource struct {
+	Group     string   `yaml:"group"`
+	Resources []string `yaml:"resources"`
+}
+
+type testSpec struct {
+	CRDs      []string          `yaml:"crds,omitempty"`
+	Weight    int               `yaml:"weight,omitempty"`
+	Disabled  bool              `yaml:"disabled,omitempty"`
+	Resources []testResource    `yaml:"resources,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    testSpec
+		wantErr string
+	}{
+		{
+			name: "valid",
+			raw:  "crds:\n  - crds/*.yaml\nweight: 2\nresources:\n  - group: example.io\n    resources: [examples]\n",
+			want: testSpec{CRDs: []string{"crds/*.yaml"}, Weight: 2, Resources: []testResource{{Group: "example.io", Resources: []string{"examples"}}}},
+		},
+		{
+			name: "empty file",
+			raw:  "",
+		},
+		{
+			name:    "unknown field",
+			raw:     "crds: []\ncrd: []\n",
+			wantErr: "rbac.yaml:2:1: unknown field 'crd'",
+		},
+		{
+			name:    "nested unknown field",
+			raw:     "resources:\n  - group: example.io\n    resource: [examples]\n",
+			wantErr: "rbac.yaml:3:5: unknown field 'resource'\nrbac.yaml:2:5: missing required field 'resources'",
+		},
+		{
+			name:    "missing required fields",
+			raw:     "resources:\n  - group: example.io\n  - {}\n",
+			wantErr: "rbac.yaml:2:5: missing required field 'resources'\nrbac.yaml:3:5: missing required field 'group'\nrbac.yaml:3:5: missing required field 'resources'",
+		},
+		{
+			name:    "scalar instead of sequence",
+			raw:     "crds: crds/*.yaml\n",
+			wantErr: "rbac.yaml:1:7: expected sequence, got 'crds/*.yaml'",
+		},
+		{
+			name:    "wrong scalar kind",
+			raw:     "weight: heavy\ndisabled: 1\n",
+			wantErr: "rbac.yaml:1:9: expected integer, got 'heavy'\nrbac.yaml:2:11: expected boolean, got '1'",
+		},
+		{
+			name:    "sequence instead of mapping",
+			raw:     "labels: [a]\n",
+			wantErr: "rbac.yaml:1:9: expected mapping, got sequence",
+		},
+		{
+			name:    "invalid yaml",
+			raw:     "crds: [\n",
+			wantErr: "rbac.yaml: yaml: line 1: did not find expected node content",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got testSpec
+			err := Decode("rbac.yaml", []byte(tt.raw), &got)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Decode() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeLenient(t *testing.T) {
+	var got testSpec
+	if err := DecodeLenient("module.yaml", []byte("weight: 2\naccessibility:\n  editions: {}\n"), &got); err != nil {
+		t.Fatalf("DecodeLenient() error = %v", err)
+	}
+	if got.Weight != 2 {
+		t.Errorf("DecodeLenient() weight = %d, want 2", got.Weight)
+	}
+
+	err := DecodeLenient("module.yaml", []byte("weight: heavy\naccessibility: {}\n"), &got)
+	if want := "module.yaml:1:9: expected integer, got 'heavy'"; err == nil || err.Error() != want {
+		t.Errorf("DecodeLenient() error = %v, want %q", err, want)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  func(title string, value any) ([]byte, error)
+		lenient bool
+	}{
+		{name: "strict", schema: JSONSchema},
+		{name: "lenient", schema: JSONSchemaLenient, lenient: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			marshaled, err := tt.schema("rbac.yaml", testSpec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				Title                string `json:"title"`
+				AdditionalProperties bool   `json:"additionalProperties"`
+				Properties           struct {
+					Resources struct {
+						Items struct {
+							AdditionalProperties bool     `json:"additionalProperties"`
+							Required             []string `json:"required"`
+						} `json:"items"`
+					} `json:"resources"`
+				} `json:"properties"`
+			}
+			if err = json.Unmarshal(marshaled, &got); err != nil {
+				t.Fatal(err)
+			}
+			items := got.Properties.Resources.Items
+			if got.Title != "rbac.yaml" || got.AdditionalProperties != tt.lenient || items.AdditionalProperties != tt.lenient {
+				t.Errorf("schema = %s, want additionalProperties %v", marshaled, tt.lenient)
+			}
+			if want := []string{"group", "resources"}; !reflect.DeepEqual(items.Required, want) {
+				t.Errorf("schema required = %v, want %v", items.Required, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociLayoutFile = "oci-layout"
+	ociIndexFile  = "index.json"
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// ociDescriptor points to a blob of the OCI image layout
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// ociManifest is an image manifest or an image index, only the fields used to find layers are decoded
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// IsBundle returns true if the path is a .tar.gz archive or an OCI image layout dir
+func IsBundle(path string) bool {
+	if strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(path, ociLayoutFile))
+	return err == nil
+}
+
+// Bundle unpacks the .tar.gz archive or the image of the OCI image layout dir into memory,
+// the files of the bundle are addressed by local paths under the bundle path
+func Bundle(bundlePath string) (*Source, error) {
+	files := make(map[string][]byte)
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		err = unpackLayout(bundlePath, files)
+	} else {
+		err = unpackFile(bundlePath, files)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack the bundle '%s': %w", bundlePath, err)
+	}
+
+	src, err := New(bundlePath, newMemoryFS(files))
+	if err != nil {
+		return nil, err
+	}
+	src.Bundle = true
+	return src, nil
+}
+
+func unpackFile(path string, files map[string][]byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return unpackTar(file, files)
+}
+
+// unpackLayout applies layers of the only image of the OCI image layout in order
+func unpackLayout(dir string, files map[string][]byte) error {
+	manifest, err := readManifest(filepath.Join(dir, ociIndexFile))
+	if err != nil {
+		return err
+	}
+	// the index may point to a nested index, like a multi-platform image
+	for len(manifest.Manifests) != 0 {
+		if len(manifest.Manifests) != 1 {
+			return fmt.Errorf("expected one image in the index, found %d", len(manifest.Manifests))
+		}
+		if manifest, err = readManifest(blobPath(dir, manifest.Manifests[0].Digest)); err != nil {
+			return err
+		}
+	}
+	if len(manifest.Layers) == 0 {
+		return errors.New("the image has no layers")
+	}
+
+	for _, layer := range manifest.Layers {
+		if err = unpackFile(blobPath(dir, layer.Digest), files); err != nil {
+			return fmt.Errorf("layer '%s': %w", layer.Digest, err)
+		}
+	}
+	return nil
+}
+
+func readManifest(path string) (*ociManifest, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(ociManifest)
+	if err = json.Unmarshal(raw, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %w", path, err)
+	}
+	return manifest, nil
+}
+
+// blobPath returns the path of the blob by its digest, like sha256:<hex>
+func blobPath(dir, digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return filepath.Join(dir, "blobs", algorithm, hex)
+}
+
+// unpackTar reads regular files of the tar stream, which may be gzipped, into the files,
+// whiteout entries of image layers remove files unpacked before
+func unpackTar(r io.Reader, files map[string][]byte) error {
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = buffered
+	}
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		name := cleanName(header.Name)
+		if name == "" {
+			continue
+		}
+
+		base, dir := path.Base(name), path.Dir(name)
+		switch {
+		case base == whiteoutOpaque:
+			removeUnder(files, dir)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			removed := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			delete(files, removed)
+			removeUnder(files, removed)
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		files[name] = data
+	}
+}
+
+// removeUnder removes files under the dir
+func removeUnder(files map[string][]byte, dir string) {
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+	for name := range files {
+		if strings.HasPrefix(name, prefix) {
+			delete(files, name)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// entry is a tar entry, entries without data are dirs
+type entry struct {
+	name string
+	data string
+	dir  bool
+}
+
+func archive(t *testing.T, entries []entry, compressed bool) []byte {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	var gz *gzip.Writer
+	writer := tar.NewWriter(buffer)
+	if compressed {
+		gz = gzip.NewWriter(buffer)
+		writer = tar.NewWriter(gz)
+	}
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg}
+		if entry.dir {
+			header = &tar.Header{Name: entry.name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buffer.Bytes()
+}
+
+func TestUnpackTar(t *testing.T) {
+	base := []entry{
+		{name: "module/", dir: true},
+		{name: "module/module.yaml", data: "name: foo"},
+		{name: "module/rbac.yaml", data: "crds: []"},
+		{name: "module/crds/a.yaml", data: "a"},
+		{name: "module/crds/b.yaml", data: "b"},
+		{name: "module/crds/internal/c.yaml", data: "c"},
+	}
+
+	tests := []struct {
+		name       string
+		layer      []entry
+		compressed bool
+		want       map[string]string
+	}{
+		{
+			name: "files are overwritten",
+			layer: []entry{
+				{name: "./module/module.yaml", data: "name: bar"},
+			},
+			want: map[string]string{
+				"module/module.yaml":          "name: bar",
+				"module/rbac.yaml":            "crds: []",
+				"module/crds/a.yaml":          "a",
+				"module/crds/b.yaml":          "b",
+				"module/crds/internal/c.yaml": "c",
+			},
+		},
+		{
+			name: "whiteout removes the file",
+			layer: []entry{
+				{name: "module/.wh.rbac.yaml"},
+			},
+			compressed: true,
+			want: map[string]string{
+				"module/module.yaml":          "name: foo",
+				"module/crds/a.yaml":          "a",
+				"module/crds/b.yaml":          "b",
+				"module/crds/internal/c.yaml": "c",
+			},
+		},
+		{
+			name: "whiteout removes the dir",
+			layer: []entry{
+				{name: "module/crds/.wh.internal"},
+			},
+			want: map[string]string{
+				"module/module.yaml": "name: foo",
+				"module/rbac.yaml":   "crds: []",
+				"module/crds/a.yaml": "a",
+				"module/crds/b.yaml": "b",
+			},
+		},
+		{
+			name: "opaque whiteout removes the dir contents before the layer",
+			layer: []entry{
+				{name: "module/crds/.wh..wh..opq"},
+				{name: "module/crds/d.yaml", data: "d"},
+			},
+			want: map[string]string{
+				"module/module.yaml": "name: foo",
+				"module/rbac.yaml":   "crds: []",
+				"module/crds/d.yaml": "d",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make(map[string][]byte)
+			for _, layer := range [][]byte{archive(t, base, false), archive(t, tt.layer, tt.compressed)} {
+				if err := unpackTar(bytes.NewReader(layer), files); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := make(map[string]string, len(files))
+			for name, data := range files {
+				got[name] = string(data)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("unpackTar() files = %v, want %v", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(tt.want)))
+			}
+		})
+	}
+}
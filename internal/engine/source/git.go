@@ -0,0 +1,181 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Git returns the source of the git revision of the repository containing the dir,
+// files are read from the local object database without checking the revision out
+func Git(dir, rev string) (*Source, error) {
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the git repository of '%s': %w", dir, err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the git repository of '%s': %w", dir, err)
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the revision '%s': %w", rev, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the revision '%s': %w", rev, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the revision '%s': %w", rev, err)
+	}
+
+	return &Source{FS: &treeFS{tree: tree, time: commit.Committer.When}, Root: worktree.Filesystem.Root(), Rev: rev}, nil
+}
+
+// treeFS is the fs.FS of the git tree, submodules are not supported
+type treeFS struct {
+	tree *object.Tree
+	// time is the commit time used as the modification time of all files
+	time time.Time
+}
+
+func (t *treeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &treeDir{info: t.info(".", filemode.Dir, 0), fs: t, tree: t.tree}, nil
+	}
+
+	entry, err := t.tree.FindEntry(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	switch {
+	case entry.Mode == filemode.Dir:
+		tree, err := t.tree.Tree(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &treeDir{info: t.info(entry.Name, entry.Mode, 0), fs: t, tree: tree}, nil
+	case entry.Mode.IsFile():
+		file, err := t.tree.TreeEntryFile(entry)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		contents, err := file.Contents()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &treeFile{info: t.info(entry.Name, entry.Mode, file.Size), Reader: bytes.NewReader([]byte(contents))}, nil
+	default:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+}
+
+func (t *treeFS) info(name string, mode filemode.FileMode, size int64) *treeInfo {
+	return &treeInfo{name: path.Base(name), mode: mode, size: size, time: t.time}
+}
+
+// treeInfo describes the git tree entry
+type treeInfo struct {
+	name string
+	mode filemode.FileMode
+	size int64
+	time time.Time
+	// parent is set for entries read from the dir, their sizes are read on demand
+	parent *object.Tree
+}
+
+func (i *treeInfo) Name() string { return i.name }
+
+func (i *treeInfo) Size() int64 {
+	if i.parent != nil {
+		i.size, _ = i.parent.Size(i.name)
+		i.parent = nil
+	}
+	return i.size
+}
+
+func (i *treeInfo) ModTime() time.Time { return i.time }
+func (i *treeInfo) IsDir() bool        { return i.mode == filemode.Dir }
+func (i *treeInfo) Sys() any           { return nil }
+
+func (i *treeInfo) Mode() fs.FileMode {
+	mode, err := i.mode.ToOSFileMode()
+	if err != nil {
+		return 0
+	}
+	return mode
+}
+
+func (i *treeInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *treeInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i *treeInfo) String() string             { return fs.FormatFileInfo(i) }
+
+type treeFile struct {
+	*bytes.Reader
+	info *treeInfo
+}
+
+func (f *treeFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *treeFile) Close() error               { return nil }
+
+type treeDir struct {
+	info *treeInfo
+	fs   *treeFS
+	tree *object.Tree
+	// read is the number of entries returned by ReadDir
+	read int
+}
+
+func (d *treeDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *treeDir) Close() error               { return nil }
+
+func (d *treeDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *treeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	for ; d.read < len(d.tree.Entries) && (n <= 0 || len(entries) < n); d.read++ {
+		entry := d.tree.Entries[d.read]
+		if entry.Mode != filemode.Dir && !entry.Mode.IsFile() {
+			continue
+		}
+		info := d.fs.info(entry.Name, entry.Mode, 0)
+		if entry.Mode.IsFile() {
+			info.parent = d.tree
+		}
+		entries = append(entries, info)
+	}
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	return entries, nil
+}
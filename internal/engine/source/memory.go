@@ -0,0 +1,135 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// memoryFS is the fs.FS of files kept in memory, dirs are derived from the file names
+type memoryFS struct {
+	files map[string][]byte
+	// dirs contains sorted names of entries of every dir
+	dirs map[string][]string
+}
+
+func newMemoryFS(files map[string][]byte) *memoryFS {
+	m := &memoryFS{files: files, dirs: map[string][]string{".": nil}}
+	for name := range files {
+		// parents of the registered child are registered too
+		for child := name; child != "."; child = path.Dir(child) {
+			parent := path.Dir(child)
+			if slices.Contains(m.dirs[parent], path.Base(child)) {
+				break
+			}
+			m.dirs[parent] = append(m.dirs[parent], path.Base(child))
+		}
+	}
+	for _, entries := range m.dirs {
+		slices.Sort(entries)
+	}
+	return m
+}
+
+func (m *memoryFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if data, ok := m.files[name]; ok {
+		return &memoryFile{Reader: bytes.NewReader(data), info: memoryInfo{name: path.Base(name), size: int64(len(data))}}, nil
+	}
+	if entries, ok := m.dirs[name]; ok {
+		return &memoryDir{fs: m, name: name, entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *memoryFS) info(name string) memoryInfo {
+	if data, ok := m.files[name]; ok {
+		return memoryInfo{name: path.Base(name), size: int64(len(data))}
+	}
+	return memoryInfo{name: path.Base(name), dir: true}
+}
+
+type memoryInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memoryInfo) Name() string       { return i.name }
+func (i memoryInfo) Size() int64        { return i.size }
+func (i memoryInfo) ModTime() time.Time { return time.Time{} }
+func (i memoryInfo) IsDir() bool        { return i.dir }
+func (i memoryInfo) Sys() any           { return nil }
+
+func (i memoryInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i memoryInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i memoryInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i memoryInfo) String() string             { return fs.FormatFileInfo(i) }
+
+type memoryFile struct {
+	*bytes.Reader
+	info memoryInfo
+}
+
+func (f *memoryFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memoryFile) Close() error               { return nil }
+
+type memoryDir struct {
+	fs      *memoryFS
+	name    string
+	entries []string
+	// read is the number of entries returned by ReadDir
+	read int
+}
+
+func (d *memoryDir) Stat() (fs.FileInfo, error) {
+	return memoryInfo{name: path.Base(d.name), dir: true}, nil
+}
+func (d *memoryDir) Close() error { return nil }
+
+func (d *memoryDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *memoryDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	for ; d.read < len(d.entries) && (n <= 0 || len(entries) < n); d.read++ {
+		entries = append(entries, d.fs.info(path.Join(d.name, d.entries[d.read])))
+	}
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	return entries, nil
+}
+
+// cleanName returns the FS name of the archive entry
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
@@ -0,0 +1,253 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Source is a read-only tree of files addressed by local paths, the paths are resolved against the current dir
+type Source struct {
+	// FS is rooted at the Root
+	FS fs.FS
+	// Root is the absolute local path of the FS root
+	Root string
+	// Rev is the git revision the files are read from, it is empty for the local disk
+	Rev string
+	// Bundle is true if the files are unpacked from a bundle into memory
+	Bundle bool
+}
+
+// New returns the source of the fs.FS mounted at the local dir, like fstest.MapFS in tests
+func New(dir string, fsys fs.FS) (*Source, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{FS: fsys, Root: root}, nil
+}
+
+// Memory returns the source of the files kept in memory and mounted at the local dir, the files are keyed by slash-separated
+// paths relative to the dir
+func Memory(dir string, files map[string][]byte) (*Source, error) {
+	cleaned := make(map[string][]byte, len(files))
+	for name, data := range files {
+		if name = cleanName(name); name != "" {
+			cleaned[name] = data
+		}
+	}
+	return New(dir, newMemoryFS(cleaned))
+}
+
+// Disk returns the source of the local filesystem
+func Disk() *Source {
+	root := string(filepath.Separator)
+	if wd, err := os.Getwd(); err == nil {
+		root = filepath.VolumeName(wd) + root
+	}
+	return &Source{FS: os.DirFS(root), Root: root}
+}
+
+// Open returns the source of the dir at the git revision, the source of the bundle if the dir is a bundle,
+// or the local filesystem
+func Open(dir, rev string) (*Source, error) {
+	switch {
+	case rev != "":
+		return Git(dir, rev)
+	case IsBundle(dir):
+		return Bundle(dir)
+	default:
+		return Disk(), nil
+	}
+}
+
+// Contains returns true if the path is inside the source root
+func (s *Source) Contains(path string) bool {
+	_, err := s.name(path)
+	return err == nil
+}
+
+func (s *Source) Open(path string) (fs.File, error) {
+	name, err := s.name(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := s.FS.Open(name)
+	return file, localErr(err, path)
+}
+
+func (s *Source) ReadFile(path string) ([]byte, error) {
+	name, err := s.name(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := fs.ReadFile(s.FS, name)
+	return raw, localErr(err, path)
+}
+
+func (s *Source) Stat(path string) (fs.FileInfo, error) {
+	name, err := s.name(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(s.FS, name)
+	return info, localErr(err, path)
+}
+
+// WalkDir walks the dir like filepath.WalkDir, the walked paths start with the dir
+func (s *Source) WalkDir(dir string, fn fs.WalkDirFunc) error {
+	name, err := s.name(dir)
+	if err != nil {
+		return err
+	}
+	return fs.WalkDir(s.FS, name, func(walked string, entry fs.DirEntry, err error) error {
+		return fn(local(dir, name, walked), entry, err)
+	})
+}
+
+// Glob returns local paths matching the pattern like filepath.Glob, '**' matches any number of nested dirs
+func (s *Source) Glob(pattern string) ([]string, error) {
+	dir, rest := splitGlob(pattern)
+	name, err := s.name(dir)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	if slices.Contains(strings.Split(rest, "/"), "**") {
+		matches, err = s.globRecursive(name, rest)
+	} else {
+		matches, err = fs.Glob(s.FS, path.Join(name, rest))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for idx, match := range matches {
+		matches[idx] = local(dir, name, match)
+	}
+	return matches, nil
+}
+
+// globRecursive walks the dir and returns names matching the pattern relative to the dir, I/O errors are ignored like by fs.Glob
+func (s *Source) globRecursive(dirName, pattern string) ([]string, error) {
+	segments := strings.Split(pattern, "/")
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	var matches []string
+	err := fs.WalkDir(s.FS, dirName, func(name string, _ fs.DirEntry, err error) error {
+		if err != nil || name == dirName {
+			return nil
+		}
+		rel := name
+		if dirName != "." {
+			rel = strings.TrimPrefix(name, dirName+"/")
+		}
+		if matchSegments(segments, strings.Split(rel, "/")) {
+			matches = append(matches, name)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// Match returns true if the slash-separated path matches the pattern, the '**' segment matches zero or more dirs
+func Match(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchSegments matches the path parts against the pattern segments, the '**' segment matches zero or more parts
+func matchSegments(segments, parts []string) bool {
+	if len(segments) == 0 {
+		return len(parts) == 0
+	}
+	if segments[0] == "**" {
+		for idx := 0; idx <= len(parts); idx++ {
+			if matchSegments(segments[1:], parts[idx:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	matched, _ := path.Match(segments[0], parts[0])
+	return matched && matchSegments(segments[1:], parts[1:])
+}
+
+// name returns the name of the local path in the FS
+func (s *Source) name(localPath string) (string, error) {
+	abs, err := filepath.Abs(localPath)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(s.Root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// files outside of the source do not exist in it
+		return "", fmt.Errorf("'%s' is outside of '%s': %w", localPath, s.Root, fs.ErrNotExist)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// localErr replaces the FS name in the path error by the local path
+func localErr(err error, localPath string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return &fs.PathError{Op: pathErr.Op, Path: localPath, Err: pathErr.Err}
+	}
+	return err
+}
+
+// local returns the local path of the FS name found under the dir with the dir name
+func local(dir, dirName, name string) string {
+	switch {
+	case name == dirName:
+		return dir
+	case dirName == ".":
+		return filepath.Join(dir, filepath.FromSlash(name))
+	default:
+		return filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(name, dirName+"/")))
+	}
+}
+
+// splitGlob splits the pattern into the leading dir without meta characters and the rest of the pattern
+func splitGlob(pattern string) (string, string) {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	idx := slices.IndexFunc(parts, func(part string) bool {
+		return strings.ContainsAny(part, `*?[\`)
+	})
+	if idx == -1 {
+		idx = len(parts) - 1
+	}
+
+	dir := filepath.FromSlash(strings.Join(parts[:idx], "/"))
+	if dir == "" {
+		dir = "."
+		if filepath.IsAbs(pattern) {
+			dir = string(filepath.Separator)
+		}
+	}
+	return dir, strings.Join(parts[idx:], "/")
+}
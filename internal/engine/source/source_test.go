@@ -0,0 +1,100 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "*.yaml", path: "a.yaml", want: true},
+		{pattern: "*.yaml", path: "nested/a.yaml", want: false},
+		{pattern: "**/*.yaml", path: "a.yaml", want: true},
+		{pattern: "**/*.yaml", path: "nested/deep/a.yaml", want: true},
+		{pattern: "**/*.yaml", path: "nested/a.yml", want: false},
+		{pattern: "crds/**/*.yaml", path: "crds/a.yaml", want: true},
+		{pattern: "crds/**/*.yaml", path: "crds/internal/a.yaml", want: true},
+		{pattern: "crds/**/*.yaml", path: "other/a.yaml", want: false},
+		{pattern: "crds/**", path: "crds/internal/a.yaml", want: true},
+		{pattern: "crds/**/internal/*.yaml", path: "crds/internal/a.yaml", want: true},
+		{pattern: "crds/**/internal/*.yaml", path: "crds/native/a.yaml", want: false},
+		{pattern: "**/**/*.yaml", path: "a.yaml", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := matchSegments(strings.Split(tt.pattern, "/"), strings.Split(tt.path, "/")); got != tt.want {
+				t.Errorf("matchSegments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "repo")
+	src, err := Memory(dir, map[string][]byte{
+		"crds/a.yaml":               nil,
+		"crds/b.yml":                nil,
+		"crds/internal/c.yaml":      nil,
+		"crds/internal/deep/d.yaml": nil,
+		"docs/e.yaml":               nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "crds/*.yaml", want: []string{"crds/a.yaml"}},
+		{pattern: "crds/**/*.yaml", want: []string{"crds/a.yaml", "crds/internal/c.yaml", "crds/internal/deep/d.yaml"}},
+		{pattern: "**/c.yaml", want: []string{"crds/internal/c.yaml"}},
+		{pattern: "crds/*/*.yaml", want: []string{"crds/internal/c.yaml"}},
+		{pattern: "missing/**/*.yaml", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := src.Glob(filepath.Join(dir, filepath.FromSlash(tt.pattern)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, path := range tt.want {
+				want = append(want, filepath.Join(dir, filepath.FromSlash(path)))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Glob() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestGlobBadPattern(t *testing.T) {
+	src, err := Memory(t.TempDir(), map[string][]byte{"a.yaml": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = src.Glob(filepath.Join(src.Root, "**", "[")); err == nil {
+		t.Error("Glob() error = nil, want the bad pattern error")
+	}
+}
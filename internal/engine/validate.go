@@ -0,0 +1,91 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/renderer"
+)
+
+// validateNames returns an error for every module name defined by more than one module.yaml
+func validateNames(modules []*models.Module) error {
+	paths := make(map[string][]string)
+	var names []string
+	for _, module := range modules {
+		if _, ok := paths[module.Definition.Name]; !ok {
+			names = append(names, module.Definition.Name)
+		}
+		paths[module.Definition.Name] = append(paths[module.Definition.Name], module.Path)
+	}
+
+	var errs []error
+	for _, name := range names {
+		if len(paths[name]) > 1 {
+			errs = append(errs, fmt.Errorf("module '%s' is defined more than once: '%s'", name, strings.Join(paths[name], "', '")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateOwnership returns an error for every resource claimed by more than one module,
+// the resource may be claimed by several modules if all of them list it in sharedResources
+func validateOwnership(rendered []*renderer.Roles) error {
+	claims := make(map[string]sets.Set[string])
+	var resources []string
+	shared := make(map[string]sets.Set[string])
+	for _, roles := range rendered {
+		name := roles.Module.Definition.Name
+		for _, parsed := range []map[string][]string{roles.Parsed.Cluster, roles.Parsed.Namespaced} {
+			for group, groupResources := range parsed {
+				for _, resource := range groupResources {
+					key := resource + "." + group
+					if _, ok := claims[key]; !ok {
+						claims[key] = sets.New[string]()
+						resources = append(resources, key)
+					}
+					claims[key].Insert(name)
+				}
+			}
+		}
+		for _, resource := range roles.Module.Spec.SharedResources {
+			for _, sharedResource := range resource.Resources {
+				key := sharedResource + "." + resource.Group
+				if _, ok := shared[key]; !ok {
+					shared[key] = sets.New[string]()
+				}
+				shared[key].Insert(name)
+			}
+		}
+	}
+
+	slices.Sort(resources)
+	var errs []error
+	for _, resource := range resources {
+		modules := claims[resource]
+		if modules.Len() < 2 || (shared[resource] != nil && shared[resource].IsSuperset(modules)) {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("resource '%s' is claimed by modules '%s', list it in sharedResources of every module to share it",
+			resource, strings.Join(sets.List(modules), "', '")))
+	}
+	return errors.Join(errs...)
+}
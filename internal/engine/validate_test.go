@@ -0,0 +1,99 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/parser"
+	"github.com/deckhouse/rbacgen/internal/engine/renderer"
+)
+
+// claimed returns roles of the module claiming the namespaced resources of the group
+func claimed(name, group string, resources []string, shared ...string) *renderer.Roles {
+	spec := new(models.Spec)
+	if len(shared) != 0 {
+		spec.SharedResources = []models.Resource{{Group: group, Resources: shared}}
+	}
+	return &renderer.Roles{
+		Module: &models.Module{Definition: &models.Definition{Name: name}, Spec: spec},
+		Parsed: &parser.ParsedCRDs{
+			Cluster:    map[string][]string{},
+			Namespaced: map[string][]string{group: resources},
+		},
+	}
+}
+
+func TestValidateOwnership(t *testing.T) {
+	tests := []struct {
+		name     string
+		rendered []*renderer.Roles
+		wantErr  string
+	}{
+		{
+			name: "distinct resources",
+			rendered: []*renderer.Roles{
+				claimed("a", "example.io", []string{"widgets"}),
+				claimed("b", "example.io", []string{"gadgets"}),
+			},
+		},
+		{
+			name: "same resource of other groups",
+			rendered: []*renderer.Roles{
+				claimed("a", "example.io", []string{"widgets"}),
+				claimed("b", "other.io", []string{"widgets"}),
+			},
+		},
+		{
+			name: "claimed by two modules",
+			rendered: []*renderer.Roles{
+				claimed("a", "example.io", []string{"widgets", "gadgets"}),
+				claimed("b", "example.io", []string{"widgets"}),
+			},
+			wantErr: "resource 'widgets.example.io' is claimed by modules 'a', 'b', list it in sharedResources of every module to share it",
+		},
+		{
+			name: "shared by every module",
+			rendered: []*renderer.Roles{
+				claimed("a", "example.io", []string{"widgets"}, "widgets"),
+				claimed("b", "example.io", []string{"widgets"}, "widgets"),
+			},
+		},
+		{
+			name: "shared by one of modules",
+			rendered: []*renderer.Roles{
+				claimed("a", "example.io", []string{"widgets"}, "widgets"),
+				claimed("b", "example.io", []string{"widgets"}),
+				claimed("c", "example.io", []string{"widgets"}, "widgets"),
+			},
+			wantErr: "resource 'widgets.example.io' is claimed by modules 'a', 'b', 'c', list it in sharedResources of every module to share it",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOwnership(tt.rendered)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateOwnership() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("validateOwnership() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
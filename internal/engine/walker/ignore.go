@@ -0,0 +1,186 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package walker
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+)
+
+const gitignoreFile = ".gitignore"
+
+// ignorer matches paths against .gitignore files and the config globs
+type ignorer struct {
+	src *source.Source
+	dir string
+	// gitRoot is the root of the git repository the dir belongs to, patterns domains are relative to it
+	gitRoot  string
+	patterns []gitignore.Pattern
+	include  []string
+	exclude  []string
+}
+
+func newIgnorer(src *source.Source, dir string, cfg *config.Config) (*ignorer, error) {
+	i := &ignorer{src: src, dir: dir, include: cfg.Include, exclude: cfg.Exclude}
+	// bundles are not parts of repositories
+	if !cfg.Gitignore || src.Bundle {
+		return i, nil
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	i.gitRoot = findGitRoot(abs)
+	if i.gitRoot == "" {
+		i.gitRoot = abs
+	}
+
+	if err = i.readPatterns(filepath.Join(i.gitRoot, ".git", "info", "exclude"), nil); err != nil {
+		return nil, err
+	}
+
+	// .gitignore files above the dir are applied too
+	rel, err := filepath.Rel(i.gitRoot, abs)
+	if err != nil {
+		return nil, err
+	}
+	var domain []string
+	if err = i.readPatterns(filepath.Join(i.gitRoot, gitignoreFile), domain); err != nil {
+		return nil, err
+	}
+	if rel != "." {
+		for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+			domain = append(domain, part)
+			if err = i.readPatterns(filepath.Join(i.gitRoot, filepath.Join(domain...), gitignoreFile), slices.Clone(domain)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return i, nil
+}
+
+// enter reads the .gitignore file of the dir under the walked dir
+func (i *ignorer) enter(path string) error {
+	if i.gitRoot == "" || path == i.dir {
+		return nil
+	}
+	domain, err := i.split(path)
+	if err != nil {
+		return err
+	}
+	return i.readPatterns(filepath.Join(path, gitignoreFile), domain)
+}
+
+// ignored returns true if the path is ignored by .gitignore files or excluded by the config
+func (i *ignorer) ignored(path string, isDir bool) bool {
+	if rel, err := filepath.Rel(i.dir, path); err == nil && rel != "." && matchAny(i.exclude, filepath.ToSlash(rel)) {
+		return true
+	}
+	if i.gitRoot == "" || len(i.patterns) == 0 {
+		return false
+	}
+	parts, err := i.split(path)
+	if err != nil || len(parts) == 0 {
+		return false
+	}
+	return gitignore.NewMatcher(i.patterns).Match(parts, isDir)
+}
+
+// included returns true if the module dir matches include globs, all modules are included if there are no globs
+func (i *ignorer) included(modulePath string) bool {
+	if len(i.include) == 0 {
+		return true
+	}
+	rel, err := filepath.Rel(i.dir, modulePath)
+	return err == nil && matchAny(i.include, filepath.ToSlash(rel))
+}
+
+// split returns path parts relative to the git root
+func (i *ignorer) split(path string) ([]string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(i.gitRoot, abs)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "." {
+		return nil, nil
+	}
+	return strings.Split(filepath.ToSlash(rel), "/"), nil
+}
+
+func (i *ignorer) readPatterns(path string, domain []string) error {
+	file, err := i.src.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		i.patterns = append(i.patterns, gitignore.ParsePattern(line, domain))
+	}
+	return scanner.Err()
+}
+
+func findGitRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// matchAny returns true if the slash-separated path matches any of the globs,
+// globs without a slash match the base name of the path like .gitignore patterns
+func matchAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		name := path
+		if !strings.Contains(pattern, "/") {
+			name = pathpkg.Base(path)
+		}
+		if source.Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
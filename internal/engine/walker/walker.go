@@ -15,113 +15,252 @@
 package walker
 
 import (
-	"os"
+	"fmt"
+	"io/fs"
+	"log/slog"
 	"path/filepath"
 	"slices"
+	"strings"
 
-	"gopkg.in/yaml.v3"
-
+	"github.com/deckhouse/rbacgen/internal/engine/config"
 	"github.com/deckhouse/rbacgen/internal/engine/models"
+	"github.com/deckhouse/rbacgen/internal/engine/schema"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
 )
 
-func WalkModules(dir string) ([]*models.Module, error) {
-	var modules []*models.Module
+// Discovery contains modules found in the dir
+type Discovery struct {
+	Modules []*models.Module
+	// Ignored contains module.yaml files in paths ignored by .gitignore files or the config globs
+	Ignored []string
+}
 
-	err := walk(dir, []string{"internal", "crds", "testdata", "docs", ".github"}, func(path string) error {
-		if filepath.Base(path) == models.DefinitionFile {
-			module, err := parseModule(dir, filepath.Dir(path))
-			if err != nil {
-				return err
-			}
-			if len(module.Definition.Subsystems) != 0 {
-				modules = append(modules, module)
-			}
+// Generated returns modules to generate roles for, modules without subsystems or excluded from RBAC are skipped
+func (d *Discovery) Generated() []*models.Module {
+	return Generated(d.Modules)
+}
+
+// Generated filters out modules without subsystems and modules excluded from RBAC
+func Generated(modules []*models.Module) []*models.Module {
+	var generated []*models.Module
+	for _, module := range modules {
+		if module.Definition.ExcludeFromRBAC {
+			slog.Debug("skip module excluded from RBAC", "module", module.Definition.Name, "path", module.Path)
+			continue
+		}
+		if len(module.Definition.Subsystems) == 0 {
+			slog.Debug("skip module without subsystems", "module", module.Definition.Name, "path", module.Path)
+			continue
+		}
+		generated = append(generated, module)
+	}
+	return generated
+}
+
+// Discover returns all modules found in the dir of the source
+func Discover(src *source.Source, dir string, cfg *config.Config) (*Discovery, error) {
+	ignorer, err := newIgnorer(src, dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	discovery := new(Discovery)
+	err = walk(src, dir, cfg.SkipDirs, ignorer, func(path string, ignored bool) error {
+		if filepath.Base(path) != models.DefinitionFile {
+			return nil
+		}
+		if ignored || !ignorer.included(filepath.Dir(path)) {
+			slog.Info("ignore module", "path", filepath.Dir(path))
+			discovery.Ignored = append(discovery.Ignored, path)
 			return nil
 		}
+		module, err := parseModule(src, cfg, dir, filepath.Dir(path))
+		if err != nil {
+			return fmt.Errorf("module '%s': %w", filepath.Dir(path), err)
+		}
+		slog.Debug("found module", "module", module.Definition.Name, "path", module.Path, "subsystems", module.Definition.Subsystems)
+		discovery.Modules = append(discovery.Modules, module)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return modules, nil
+	return discovery, nil
 }
 
-// walk walks over specific directory
-func walk(dir string, skippedDir []string, f func(path string) error) error {
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+// walk walks over specific directory, files in ignored dirs are passed as ignored
+func walk(src *source.Source, dir string, skippedDir []string, ignorer *ignorer, f func(path string, ignored bool) error) error {
+	return src.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			if len(skippedDir) > 0 && slices.Contains(skippedDir, info.Name()) {
-				return filepath.SkipDir
+		if !entry.IsDir() {
+			return f(path, ignorer.ignored(path, false))
+		}
+
+		if entry.Name() == ".git" || (len(skippedDir) > 0 && slices.Contains(skippedDir, entry.Name())) {
+			return filepath.SkipDir
+		}
+		if ignorer.ignored(path, true) {
+			slog.Debug("skip ignored dir", "path", path)
+			if err = walkIgnored(src, path, f); err != nil {
+				return err
 			}
+			return filepath.SkipDir
 		}
 
-		return f(path)
+		return ignorer.enter(path)
+	})
+}
+
+// walkIgnored passes files of the ignored dir without reading .gitignore files
+func walkIgnored(src *source.Source, dir string, f func(path string, ignored bool) error) error {
+	return src.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return f(path, true)
 	})
 }
 
-func parseModule(root, modulePath string) (*models.Module, error) {
-	def, err := parseDefinition(filepath.Join(modulePath, models.DefinitionFile))
+// DiscoverCRDs returns CRDs globs of the module dir, globs from rbac.yaml are resolved against the current dir
+func DiscoverCRDs(src *source.Source, cfg *config.Config, modulePath string) ([]string, error) {
+	spec, err := parseSpec(src, cfg, "", filepath.Join(modulePath, models.SpecFile))
+	if err != nil {
+		return nil, err
+	}
+	return spec.CRDs, nil
+}
+
+func parseModule(src *source.Source, cfg *config.Config, root, modulePath string) (*models.Module, error) {
+	def, err := parseDefinition(src, filepath.Join(modulePath, models.DefinitionFile))
 	if err != nil {
 		return nil, err
 	}
 
-	spec, err := parseSpec(root, filepath.Join(modulePath, models.SpecFile))
+	spec, err := parseSpec(src, cfg, root, filepath.Join(modulePath, models.SpecFile))
 	if err != nil {
 		return nil, err
 	}
 
-	return &models.Module{Definition: def, Spec: spec, Path: modulePath}, nil
+	return &models.Module{Definition: def, Spec: spec, Path: modulePath, Root: root, Source: src}, nil
 }
 
-func parseDefinition(path string) (*models.Definition, error) {
-	raw, err := os.ReadFile(path)
+func parseDefinition(src *source.Source, path string) (*models.Definition, error) {
+	raw, err := src.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
 	def := new(models.Definition)
-	if err = yaml.Unmarshal(raw, def); err != nil {
+	// module.yaml has fields used by other tools
+	if err = schema.DecodeLenient(path, raw, def); err != nil {
 		return nil, err
 	}
 
 	return def, nil
 }
 
-func parseSpec(root, path string) (*models.Spec, error) {
+func parseSpec(src *source.Source, cfg *config.Config, root, path string) (*models.Spec, error) {
 	spec := new(models.Spec)
 
-	if _, err := os.Stat(path); err == nil {
-		raw, err := os.ReadFile(path)
-		if err != nil {
+	if _, err := src.Stat(path); err == nil {
+		if spec, err = parseFragment(src, root, path, nil); err != nil {
 			return nil, err
 		}
+	}
 
-		if err = yaml.Unmarshal(raw, spec); err != nil {
-			return nil, err
+	// the module path already starts with the root
+	discovered, err := discoverCRDs(src, cfg.CRDDiscovery, filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	spec.CRDs = append(spec.CRDs, discovered...)
+
+	// to remove duplicate
+	spec.CRDs = slices.Compact(spec.CRDs)
+
+	return spec, nil
+}
+
+// discoverCRDs returns globs of the discovery rules which match files in the module dir
+func discoverCRDs(src *source.Source, rules config.CRDDiscovery, modulePath string) ([]string, error) {
+	var levels []string
+	if rules.Depth < 0 {
+		levels = []string{"**"}
+	} else {
+		for depth := 0; depth <= rules.Depth; depth++ {
+			levels = append(levels, strings.Repeat("*/", depth))
 		}
+	}
 
-		for idx, crd := range spec.CRDs {
-			spec.CRDs[idx] = filepath.Join(root, crd)
+	var globs []string
+	for _, dir := range rules.Dirs {
+		dir = filepath.Join(modulePath, dir)
+		if info, err := src.Stat(dir); err != nil || !info.IsDir() {
+			continue
 		}
+		for _, level := range levels {
+			for _, extension := range rules.Extensions {
+				glob := filepath.Join(dir, filepath.FromSlash(level), "*"+extension)
+				matches, err := src.Glob(glob)
+				if err != nil {
+					return nil, err
+				}
+				if len(matches) != 0 {
+					globs = append(globs, glob)
+				}
+			}
+		}
+	}
+	return globs, nil
+}
+
+// parseFragment reads the rbac.yaml or the fragment file and merges the fragments it extends into it,
+// entries of the file itself are merged before the entries of the fragments, the chain contains the files extending it
+func parseFragment(src *source.Source, root, path string, chain []string) (*models.Spec, error) {
+	if slices.Contains(chain, path) {
+		return nil, fmt.Errorf("cyclic extends: '%s'", strings.Join(append(chain, path), "' -> '"))
 	}
 
-	crdPath := filepath.Join(filepath.Dir(path), "crds")
-	if _, err := os.Stat(crdPath); err == nil {
-		spec.CRDs = append(spec.CRDs, filepath.Join(root, crdPath, "*.yaml"))
-		if _, err = os.Stat(filepath.Join(crdPath, "internal")); err == nil {
-			spec.CRDs = append(spec.CRDs, filepath.Join(root, crdPath, "internal", "*.yaml"))
+	raw, err := src.ReadFile(path)
+	if err != nil {
+		if len(chain) != 0 {
+			return nil, fmt.Errorf("fragment extended by '%s': %w", chain[len(chain)-1], err)
 		}
-		if _, err = os.Stat(filepath.Join(crdPath, "native")); err == nil {
-			spec.CRDs = append(spec.CRDs, filepath.Join(crdPath, "native", "*.yaml"))
+		return nil, err
+	}
+	spec := new(models.Spec)
+	if err = schema.Decode(path, raw, spec); err != nil {
+		return nil, err
+	}
+	for idx, crd := range spec.CRDs {
+		// negated globs exclude files
+		if negated, ok := strings.CutPrefix(crd, "!"); ok {
+			spec.CRDs[idx] = "!" + filepath.Join(root, negated)
+			continue
 		}
+		spec.CRDs[idx] = filepath.Join(root, crd)
 	}
+	spec.SetOrigins(path)
 
-	// to remove duplicate
-	spec.CRDs = slices.Compact(spec.CRDs)
+	for _, extended := range spec.Extends {
+		if !filepath.IsAbs(extended) {
+			extended = filepath.Join(filepath.Dir(path), extended)
+		}
+		fragment, err := parseFragment(src, root, extended, append(slices.Clone(chain), path))
+		if err != nil {
+			return nil, err
+		}
+		spec.Merge(fragment)
+	}
 
 	return spec, nil
 }
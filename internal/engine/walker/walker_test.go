@@ -0,0 +1,97 @@
+// Copyright 2024 Flant JSC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package walker
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/deckhouse/rbacgen/internal/engine/config"
+	"github.com/deckhouse/rbacgen/internal/engine/source"
+)
+
+func TestDiscoverGlobs(t *testing.T) {
+	files := map[string][]byte{
+		"modules/foo/module.yaml":               []byte("name: foo\n"),
+		"modules/999-bar/module.yaml":           []byte("name: bar\n"),
+		"ee/modules/baz/module.yaml":            []byte("name: baz\n"),
+		"ee/se/modules/qux/module.yaml":         []byte("name: qux\n"),
+		"modules/foo/testdata/mock/module.yaml": []byte("name: mock\n"),
+	}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "no globs",
+			want: []string{"bar", "baz", "foo", "mock", "qux"},
+		},
+		{
+			name:    "include one level",
+			include: []string{"modules/*"},
+			want:    []string{"bar", "foo"},
+		},
+		{
+			name:    "include nested dirs",
+			include: []string{"**/modules/*"},
+			want:    []string{"bar", "baz", "foo", "qux"},
+		},
+		{
+			name:    "include base name",
+			include: []string{"ba?"},
+			want:    []string{"baz"},
+		},
+		{
+			name:    "exclude nested dirs",
+			exclude: []string{"ee/**"},
+			want:    []string{"bar", "foo", "mock"},
+		},
+		{
+			name:    "exclude base name",
+			exclude: []string{"testdata", "999-*"},
+			want:    []string{"baz", "foo", "qux"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "repo")
+			src, err := source.Memory(dir, files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := config.Default()
+			cfg.SkipDirs = nil
+			cfg.Gitignore = false
+			cfg.Include, cfg.Exclude = tt.include, tt.exclude
+
+			discovery, err := Discover(src, dir, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, module := range discovery.Modules {
+				got = append(got, module.Definition.Name)
+			}
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Discover() modules = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}